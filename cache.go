@@ -0,0 +1,220 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/protobuf/proto"
+)
+
+// Error returned by Cache when a key is not cached
+var ErrCacheNotFound = errors.New("cache: key not found")
+
+// A codec encodes cached values of type T
+type Codec[T any] interface {
+	Marshal(value T) ([]byte, error)
+	Unmarshal(data []byte) (T, error)
+}
+
+// A codec encoding values as JSON
+type JsonCodec[T any] struct{}
+
+func (JsonCodec[T]) Marshal(value T) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JsonCodec[T]) Unmarshal(data []byte) (T, error) {
+	var value T
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+// A codec encoding protocol buffers, where T is a message pointer type, e.g.,
+// ProtobufCodec[*pb.User]
+type ProtobufCodec[T proto.Message] struct{}
+
+func (ProtobufCodec[T]) Marshal(value T) ([]byte, error) {
+	return proto.Marshal(value)
+}
+
+func (ProtobufCodec[T]) Unmarshal(data []byte) (T, error) {
+	var zero T
+	value := zero.ProtoReflect().New().Interface().(T)
+	err := proto.Unmarshal(data, value)
+	return value, err
+}
+
+// A codec encoding values as MessagePack, more compact than JSON
+type MsgpackCodec[T any] struct{}
+
+func (MsgpackCodec[T]) Marshal(value T) ([]byte, error) {
+	return msgpack.Marshal(value)
+}
+
+func (MsgpackCodec[T]) Unmarshal(data []byte) (T, error) {
+	var value T
+	err := msgpack.Unmarshal(data, &value)
+	return value, err
+}
+
+// A codec encoding values with encoding/gob
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Marshal(value T) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := gob.NewEncoder(&buffer).Encode(value)
+	return buffer.Bytes(), err
+}
+
+func (GobCodec[T]) Unmarshal(data []byte) (T, error) {
+	var value T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	return value, err
+}
+
+// Default maximum time taken to load a value, see Cache.GetOrLoad
+const DefaultCacheLoadTimeout = 30 * time.Second
+
+// Options for a Cache
+type CacheOptions struct {
+	// Prefix of the Redis keys of the cache, separated from each key by a colon,
+	// e.g., "users" for keys "users:42"
+	Namespace string
+	// Expiry of cached values; zero values do not expire
+	TTL time.Duration
+	// Time a value is kept after its TTL, during which GetOrLoad returns the
+	// stale value while reloading it in the background (stale-while-revalidate)
+	StaleTTL time.Duration
+	// Scale of the probabilistic early expiration of values by GetOrLoad, which
+	// reloads values in the background shortly before they expire, more likely
+	// the longer loads take; 1 is typical, zero disables early expiration
+	EarlyExpiryBeta float64
+	// TTL of the Redis lock held while loading a value, so other processes wait
+	// for the value rather than also loading it.  Defaults to the LoadTimeout, so
+	// the lock is held until the load finishes or times out; with a shorter TTL,
+	// other processes also load values that take longer to load.
+	LoadLockTTL time.Duration
+	// Maximum time taken to load a value, including waiting for another process
+	// to load it; defaults to DefaultCacheLoadTimeout
+	LoadTimeout time.Duration
+}
+
+// A typed cache of values in Redis, encoded with a codec
+type Cache[T any] struct {
+	redis           *Redis
+	codec           Codec[T]
+	namespace       string
+	ttl             time.Duration
+	staleTTL        time.Duration
+	earlyExpiryBeta float64
+	loadLockTTL     time.Duration
+	loadTimeout     time.Duration
+
+	loads singleflight.Group
+
+	mu sync.Mutex
+	// Moving average of the time taken to load values
+	loadDuration time.Duration
+}
+
+// NewCache creates a cache of values of type T, e.g.,
+//
+//	users := NewCache(redis, ProtobufCodec[*pb.User]{}, CacheOptions{Namespace: "users", TTL: time.Hour})
+func NewCache[T any](redis *Redis, codec Codec[T], options CacheOptions) *Cache[T] {
+	loadTimeout := options.LoadTimeout
+	if loadTimeout <= 0 {
+		loadTimeout = DefaultCacheLoadTimeout
+	}
+	loadLockTTL := options.LoadLockTTL
+	if loadLockTTL <= 0 {
+		loadLockTTL = loadTimeout
+	}
+
+	return &Cache[T]{
+		redis:           redis,
+		codec:           codec,
+		namespace:       options.Namespace,
+		ttl:             options.TTL,
+		staleTTL:        options.StaleTTL,
+		earlyExpiryBeta: options.EarlyExpiryBeta,
+		loadLockTTL:     loadLockTTL,
+		loadTimeout:     loadTimeout,
+	}
+}
+
+// Key returns the Redis key of a cache key
+func (c *Cache[T]) Key(key string) string {
+	if c.namespace == "" {
+		return key
+	}
+	return c.namespace + ":" + key
+}
+
+// Get returns the cached value, including stale values, or ErrCacheNotFound if
+// the key is not cached
+func (c *Cache[T]) Get(ctx context.Context, key string) (T, error) {
+	var value T
+
+	data, err := c.redis.getBytes(ctx, c.Key(key))
+	if errors.Is(err, redis.Nil) {
+		return value, ErrCacheNotFound
+	} else if err != nil {
+		return value, err
+	}
+
+	return c.decode(key, data)
+}
+
+func (c *Cache[T]) decode(key string, data []byte) (T, error) {
+	value, err := c.codec.Unmarshal(data)
+	if err != nil {
+		return value, fmt.Errorf("decoding cached %s: %w", c.Key(key), err)
+	}
+
+	return value, nil
+}
+
+// Set caches the value with the default TTL
+func (c *Cache[T]) Set(ctx context.Context, key string, value T) error {
+	return c.SetWithTTL(ctx, key, value, c.ttl)
+}
+
+// SetWithTTL caches the value with the given TTL, after which it is kept for
+// the StaleTTL; a zero TTL does not expire
+func (c *Cache[T]) SetWithTTL(ctx context.Context, key string, value T, ttl time.Duration) error {
+	data, err := c.codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encoding cached %s: %w", c.Key(key), err)
+	}
+
+	if ttl > 0 {
+		ttl += c.staleTTL
+	}
+
+	if err := c.redis.Set(ctx, c.Key(key), data, ttl).Err(); err != nil {
+		return err
+	}
+	c.redis.invalidateLocal(ctx, c.Key(key))
+
+	return nil
+}
+
+// Delete removes the key from the cache; deleting a key that is not cached is
+// not an error
+func (c *Cache[T]) Delete(ctx context.Context, key string) error {
+	if err := c.redis.Del(ctx, c.Key(key)).Err(); err != nil {
+		return err
+	}
+	c.redis.invalidateLocal(ctx, c.Key(key))
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	mathrand "math/rand/v2"
+	"time"
+
+	"github.com/Adapptor/service/v2/log"
+	"github.com/redis/go-redis/v9"
+)
+
+// Interval between checks for a value being loaded by another process
+const cacheLoadPollInterval = 50 * time.Millisecond
+
+// GetOrLoad returns the cached value, or if the key is not cached, calls loader
+// and caches its value with the given TTL, or the default TTL if zero.  An
+// error reading the cache is returned without calling loader.
+//
+// The load is not cancelled with ctx, as other callers may be waiting for it,
+// but is limited to the LoadTimeout; GetOrLoad returns when ctx is done
+// without waiting for the load to finish.
+//
+// Concurrent loads of a key are deduplicated: within the process, callers wait
+// for a single call of loader, and across processes, a Redis lock is held
+// while loading, so other processes wait for the value to be cached.
+//
+// Stale values within the StaleTTL, and values due for early expiration (see
+// CacheOptions.EarlyExpiryBeta), are returned while the value is reloaded in
+// the background.
+func (c *Cache[T]) GetOrLoad(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (T, error)) (T, error) {
+	value, remaining, err := c.getWithTTL(ctx, key)
+	if err == nil {
+		if c.shouldRefresh(remaining) {
+			c.refresh(ctx, key, ttl, loader)
+		}
+		return value, nil
+	} else if !errors.Is(err, ErrCacheNotFound) {
+		return value, err
+	}
+
+	results := c.loads.DoChan(c.Key(key), func() (interface{}, error) {
+		// The load is shared by every caller waiting for the key, so is not
+		// cancelled with the context of the caller that started it
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.loadTimeout)
+		defer cancel()
+
+		return c.load(ctx, key, ttl, loader, true)
+	})
+
+	var zero T
+	select {
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case result := <-results:
+		if result.Err != nil {
+			return zero, result.Err
+		}
+		// The value is nil if T is an interface type and loader returned nil
+		value, _ := result.Val.(T)
+		return value, nil
+	}
+}
+
+// getWithTTL returns the cached value and the remaining time until its key
+// expires, negative if the key does not expire
+func (c *Cache[T]) getWithTTL(ctx context.Context, key string) (T, time.Duration, error) {
+	var value T
+
+	data, remaining, err := c.redis.getBytesWithTTL(ctx, c.Key(key))
+	if errors.Is(err, redis.Nil) {
+		return value, 0, ErrCacheNotFound
+	} else if err != nil {
+		return value, 0, err
+	}
+
+	value, err = c.decode(key, data)
+	return value, remaining, err
+}
+
+// shouldRefresh reports whether a value with the given remaining time until its
+// key expires is stale or due for early expiration
+func (c *Cache[T]) shouldRefresh(remaining time.Duration) bool {
+	if remaining < 0 {
+		return false
+	}
+
+	fresh := remaining - c.staleTTL
+	if fresh <= 0 {
+		return true
+	}
+
+	if c.earlyExpiryBeta <= 0 {
+		return false
+	}
+
+	// XFetch: expire early with a probability increasing as the expiry nears,
+	// scaled by the time taken to load values
+	c.mu.Lock()
+	loadDuration := c.loadDuration
+	c.mu.Unlock()
+
+	return float64(loadDuration)*c.earlyExpiryBeta*-math.Log(1-mathrand.Float64()) >= float64(fresh)
+}
+
+// refresh reloads the value in the background, unless it is already being
+// reloaded by this or another process
+func (c *Cache[T]) refresh(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (T, error)) {
+	// The refresh outlives the request
+	ctx = context.WithoutCancel(ctx)
+
+	go func() {
+		// Separate from loads of missing values, which wait for the value
+		_, err, _ := c.loads.Do(c.Key(key)+"\x00refresh", func() (interface{}, error) {
+			ctx, cancel := context.WithTimeout(ctx, c.loadTimeout)
+			defer cancel()
+
+			return c.load(ctx, key, ttl, loader, false)
+		})
+		if err != nil {
+			log.Log(log.Warning, "Failed to refresh cached "+c.Key(key), err, ctx)
+		}
+	}()
+}
+
+// load calls loader and caches its value while holding the load lock.  If
+// another process holds the lock, load waits for the value if wait is true,
+// otherwise returns the zero value.
+func (c *Cache[T]) load(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (T, error), wait bool) (T, error) {
+	var zero T
+	lockKey := c.loadLockKey(key)
+
+	for {
+		token, err := c.acquireLoadLock(ctx, lockKey)
+		if err != nil {
+			return zero, err
+		}
+
+		if token != "" {
+			return c.loadLocked(ctx, key, ttl, loader, lockKey, token, wait)
+		}
+
+		if !wait {
+			return zero, nil
+		}
+
+		value, err := c.waitForLoad(ctx, key, lockKey)
+		if !errors.Is(err, ErrCacheNotFound) {
+			return value, err
+		}
+		// The lock was released without caching a value, e.g., the load failed, so
+		// try to load the value
+	}
+}
+
+// loadLockKey returns the key of the load lock of a cache key, outside the
+// cache's namespace so it cannot collide with a cache key (e.g., "1234:lock"),
+// in the form of the keys of Redis.Lock
+func (c *Cache[T]) loadLockKey(key string) string {
+	return "lock:{" + c.Key(key) + "}"
+}
+
+// loadLocked loads and caches the value, then releases the lock
+func (c *Cache[T]) loadLocked(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (T, error), lockKey string, token string, wait bool) (T, error) {
+	defer releaseLockScript.Run(context.WithoutCancel(ctx), c.redis, []string{lockKey}, token)
+
+	if wait {
+		// Another process may have cached the value before the lock was acquired
+		if value, err := c.Get(ctx, key); !errors.Is(err, ErrCacheNotFound) {
+			return value, err
+		}
+	}
+
+	start := time.Now()
+	value, err := loader(ctx)
+	if err != nil {
+		return value, err
+	}
+	c.recordLoadDuration(time.Since(start))
+
+	if ttl == 0 {
+		ttl = c.ttl
+	}
+	if err := c.SetWithTTL(ctx, key, value, ttl); err != nil {
+		return value, err
+	}
+
+	return value, nil
+}
+
+// acquireLoadLock returns a token identifying the lock if it was acquired, or
+// an empty string if the lock is held by another process
+func (c *Cache[T]) acquireLoadLock(ctx context.Context, lockKey string) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	acquired, err := c.redis.SetNX(ctx, lockKey, token, c.loadLockTTL).Result()
+	if err != nil || !acquired {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// waitForLoad waits for another process holding the lock to cache the value,
+// returning ErrCacheNotFound if the lock is released without caching a value
+func (c *Cache[T]) waitForLoad(ctx context.Context, key string, lockKey string) (T, error) {
+	var zero T
+
+	ticker := time.NewTicker(cacheLoadPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		case <-ticker.C:
+		}
+
+		if value, err := c.Get(ctx, key); !errors.Is(err, ErrCacheNotFound) {
+			return value, err
+		}
+
+		locked, err := c.redis.Exists(ctx, lockKey).Result()
+		if err != nil {
+			return zero, err
+		}
+		if locked == 0 {
+			return zero, ErrCacheNotFound
+		}
+	}
+}
+
+// recordLoadDuration updates the moving average of the time taken to load values
+func (c *Cache[T]) recordLoadDuration(duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.loadDuration == 0 {
+		c.loadDuration = duration
+	} else {
+		c.loadDuration = (4*c.loadDuration + duration) / 5
+	}
+}
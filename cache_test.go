@@ -0,0 +1,334 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type cachedOrder struct {
+	Id    int
+	Items []string
+	Total float64
+}
+
+func testCodecRoundTrip[T any](t *testing.T, codec Codec[T], value T, equal func(a, b T) bool) {
+	t.Helper()
+
+	data, err := codec.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := codec.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(value, decoded) {
+		t.Errorf("expected %v, got %v", value, decoded)
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	order := cachedOrder{Id: 1234, Items: []string{"coffee", "muffin"}, Total: 9.5}
+	equal := func(a, b cachedOrder) bool { return reflect.DeepEqual(a, b) }
+
+	t.Run("json", func(t *testing.T) { testCodecRoundTrip(t, JsonCodec[cachedOrder]{}, order, equal) })
+	t.Run("msgpack", func(t *testing.T) { testCodecRoundTrip(t, MsgpackCodec[cachedOrder]{}, order, equal) })
+	t.Run("gob", func(t *testing.T) { testCodecRoundTrip(t, GobCodec[cachedOrder]{}, order, equal) })
+	t.Run("protobuf", func(t *testing.T) {
+		testCodecRoundTrip(t, ProtobufCodec[*wrapperspb.StringValue]{}, wrapperspb.String("order 1234"), func(a, b *wrapperspb.StringValue) bool {
+			return proto.Equal(a, b)
+		})
+	})
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	cache := NewCache(r, JsonCodec[cachedOrder]{}, CacheOptions{Namespace: testKeyPrefix(t, r) + "orders", TTL: time.Minute})
+
+	if _, err := cache.Get(ctx, "1234"); !errors.Is(err, ErrCacheNotFound) {
+		t.Errorf("expected ErrCacheNotFound, got %v", err)
+	}
+
+	order := cachedOrder{Id: 1234, Items: []string{"coffee"}, Total: 4.5}
+	if err := cache.Set(ctx, "1234", order); err != nil {
+		t.Fatal(err)
+	}
+	if cached, err := cache.Get(ctx, "1234"); err != nil || !reflect.DeepEqual(cached, order) {
+		t.Errorf("unexpected cached order %v, %v", cached, err)
+	}
+	if ttl, err := r.TTL(ctx, cache.Key("1234")).Result(); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected the TTL to be set, got %v, %v", ttl, err)
+	}
+
+	if err := cache.Delete(ctx, "1234"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(ctx, "1234"); !errors.Is(err, ErrCacheNotFound) {
+		t.Errorf("expected ErrCacheNotFound after delete, got %v", err)
+	}
+	if err := cache.Delete(ctx, "1234"); err != nil {
+		t.Errorf("expected deleting a missing key to succeed, got %v", err)
+	}
+
+	// Values that cannot be decoded are errors rather than misses
+	if err := r.Set(ctx, cache.Key("corrupt"), "not json", time.Minute).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(ctx, "corrupt"); err == nil || errors.Is(err, ErrCacheNotFound) {
+		t.Errorf("expected a decoding error, got %v", err)
+	}
+}
+
+// Test concurrent loads of a key call the loader once
+func TestCacheGetOrLoadConcurrently(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	cache := NewCache(r, JsonCodec[cachedOrder]{}, CacheOptions{Namespace: testKeyPrefix(t, r) + "orders", TTL: time.Minute})
+
+	var loads atomic.Int32
+	loader := func(ctx context.Context) (cachedOrder, error) {
+		loads.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		return cachedOrder{Id: 1234}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if order, err := cache.GetOrLoad(ctx, "1234", 0, loader); err != nil || order.Id != 1234 {
+				t.Errorf("unexpected order %v, %v", order, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if loads.Load() != 1 {
+		t.Errorf("expected 1 load, got %d", loads.Load())
+	}
+	if order, err := cache.Get(ctx, "1234"); err != nil || order.Id != 1234 {
+		t.Errorf("expected the loaded order to be cached, got %v, %v", order, err)
+	}
+}
+
+// Test a nil value of an interface type is loaded and cached
+func TestCacheGetOrLoadNil(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	cache := NewCache(r, JsonCodec[any]{}, CacheOptions{Namespace: testKeyPrefix(t, r) + "settings", TTL: time.Minute})
+
+	loader := func(ctx context.Context) (any, error) {
+		return nil, nil
+	}
+
+	if value, err := cache.GetOrLoad(ctx, "theme", 0, loader); err != nil || value != nil {
+		t.Errorf("expected a nil value, got %v, %v", value, err)
+	}
+	if value, err := cache.Get(ctx, "theme"); err != nil || value != nil {
+		t.Errorf("expected the nil value to be cached, got %v, %v", value, err)
+	}
+}
+
+// Test the load lock is held until the load times out by default
+func TestCacheGetOrLoadLockTTL(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	cache := NewCache(r, JsonCodec[cachedOrder]{}, CacheOptions{Namespace: testKeyPrefix(t, r) + "orders", TTL: time.Minute, LoadTimeout: 5 * time.Second})
+
+	loader := func(ctx context.Context) (cachedOrder, error) {
+		if ttl, err := r.PTTL(ctx, cache.loadLockKey("1234")).Result(); err != nil || ttl <= 4*time.Second {
+			t.Errorf("expected the load lock to be held for the load timeout, got %v, %v", ttl, err)
+		}
+		return cachedOrder{Id: 1234}, nil
+	}
+
+	if _, err := cache.GetOrLoad(ctx, "1234", 0, loader); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test a caller cancelling its context does not cancel the load for other callers
+func TestCacheGetOrLoadCallerCancelled(t *testing.T) {
+	r := testRedis(t)
+	cache := NewCache(r, JsonCodec[cachedOrder]{}, CacheOptions{Namespace: testKeyPrefix(t, r) + "orders", TTL: time.Minute})
+
+	loader := func(ctx context.Context) (cachedOrder, error) {
+		time.Sleep(200 * time.Millisecond)
+		return cachedOrder{Id: 1234}, ctx.Err()
+	}
+
+	cancelled, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := cache.GetOrLoad(cancelled, "1234", 0, loader)
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	if order, err := cache.GetOrLoad(context.Background(), "1234", 0, loader); err != nil || order.Id != 1234 {
+		t.Errorf("unexpected order %v, %v", order, err)
+	}
+	if err := <-errs; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the cancelled caller to return its context error, got %v", err)
+	}
+}
+
+// Test stale values are returned while they are reloaded in the background
+func TestCacheGetOrLoadStale(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	cache := NewCache(r, JsonCodec[cachedOrder]{}, CacheOptions{Namespace: testKeyPrefix(t, r) + "orders", TTL: time.Minute, StaleTTL: time.Minute})
+
+	// Within the StaleTTL of its expiry
+	if err := r.Set(ctx, cache.Key("1234"), `{"Id":1234,"Total":4.5}`, 30*time.Second).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := make(chan struct{})
+	loader := func(ctx context.Context) (cachedOrder, error) {
+		defer close(reloaded)
+		return cachedOrder{Id: 1234, Total: 5}, nil
+	}
+
+	if order, err := cache.GetOrLoad(ctx, "1234", 0, loader); err != nil || order.Total != 4.5 {
+		t.Errorf("expected the stale order, got %v, %v", order, err)
+	}
+
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stale order not reloaded")
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if order, err := cache.Get(ctx, "1234"); err == nil && order.Total == 5 {
+			return
+		}
+	}
+	t.Error("reloaded order not cached")
+}
+
+// Test stale values cached locally are also reloaded
+func TestCacheGetOrLoadStaleLocal(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	prefix := testKeyPrefix(t, r)
+	if err := r.EnableLocalCache(ctx, LocalCacheOptions{Channel: prefix + "invalidate"}); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewCache(r, JsonCodec[cachedOrder]{}, CacheOptions{Namespace: prefix + "orders", TTL: time.Minute, StaleTTL: time.Minute})
+
+	// Within the StaleTTL of its expiry, and cached locally
+	if err := r.Set(ctx, cache.Key("1234"), `{"Id":1234,"Total":4.5}`, 30*time.Second).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(ctx, "1234"); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := make(chan struct{})
+	loader := func(ctx context.Context) (cachedOrder, error) {
+		defer close(reloaded)
+		return cachedOrder{Id: 1234, Total: 5}, nil
+	}
+
+	hits := r.LocalCacheMetrics().LocalHits
+	if order, err := cache.GetOrLoad(ctx, "1234", 0, loader); err != nil || order.Total != 4.5 {
+		t.Errorf("expected the stale order, got %v, %v", order, err)
+	}
+	if r.LocalCacheMetrics().LocalHits != hits+1 {
+		t.Error("expected the stale order to be read from the local cache")
+	}
+
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stale order not reloaded")
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if order, err := cache.Get(ctx, "1234"); err == nil && order.Total == 5 {
+			return
+		}
+	}
+	t.Error("reloaded order not cached")
+}
+
+// Test a cache key ending in ":lock" does not collide with the load lock of another key
+func TestCacheGetOrLoadLockKey(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	cache := NewCache(r, JsonCodec[cachedOrder]{}, CacheOptions{Namespace: testKeyPrefix(t, r) + "orders", TTL: time.Minute})
+
+	if err := cache.Set(ctx, "1234:lock", cachedOrder{Id: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	loadCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	loader := func(ctx context.Context) (cachedOrder, error) {
+		return cachedOrder{Id: 1234}, nil
+	}
+	if order, err := cache.GetOrLoad(loadCtx, "1234", 0, loader); err != nil || order.Id != 1234 {
+		t.Errorf("expected the order to be loaded, got %v, %v", order, err)
+	}
+	if order, err := cache.Get(ctx, "1234:lock"); err != nil || order.Id != 1 {
+		t.Errorf("expected the other cache key to be unchanged, got %v, %v", order, err)
+	}
+}
+
+// Test callers wait for a value loaded by another process holding the load lock
+func TestCacheGetOrLoadWaitsForLockHolder(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	cache := NewCache(r, JsonCodec[cachedOrder]{}, CacheOptions{Namespace: testKeyPrefix(t, r) + "orders", TTL: time.Minute})
+
+	lockKey := cache.loadLockKey("1234")
+	if err := r.Set(ctx, lockKey, "other process", time.Minute).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cache.Set(ctx, "1234", cachedOrder{Id: 1234, Total: 4.5})
+		r.Del(ctx, lockKey)
+	}()
+
+	var loads atomic.Int32
+	loader := func(ctx context.Context) (cachedOrder, error) {
+		loads.Add(1)
+		return cachedOrder{Id: 1234}, nil
+	}
+
+	if order, err := cache.GetOrLoad(ctx, "1234", 0, loader); err != nil || order.Total != 4.5 {
+		t.Errorf("expected the order loaded by the lock holder, got %v, %v", order, err)
+	}
+	if loads.Load() != 0 {
+		t.Errorf("expected the loader not to be called, got %d loads", loads.Load())
+	}
+
+	// The value is loaded if the lock is released without caching a value
+	lockKey = cache.loadLockKey("5678")
+	if err := r.Set(ctx, lockKey, "other process", time.Minute).Err(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		r.Del(ctx, lockKey)
+	}()
+
+	if order, err := cache.GetOrLoad(ctx, "5678", 0, loader); err != nil || order.Id != 1234 || loads.Load() != 1 {
+		t.Errorf("expected the order to be loaded after the lock is released, got %v, %v", order, err)
+	}
+}
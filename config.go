@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
+
+	"github.com/Adapptor/service/v2/log"
 )
 
 type GoogleConfig struct {
@@ -18,6 +21,11 @@ type BaseConfig struct {
 	Version     string
 	Google      GoogleConfig
 	SentryDsn   *string
+	// Fraction of Sentry performance tracing transactions to send, between 0 and 1; 0 disables tracing.
+	// Used by log.ConfigureFromConfig for the sentry sink
+	SentryTracesSampleRate float64
+	// Log sinks, see log.ConfigureFromConfig
+	Logging log.Config
 }
 
 type IBaseConfig interface {
@@ -64,6 +72,62 @@ func (c *BaseConfig) GetVersionString() string {
 	}
 }
 
+// GetOtlpResource returns the OpenTelemetry resource attributes identifying the service, for log.NewOtlpLogger
+func (c *BaseConfig) GetOtlpResource() log.OtlpResource {
+	if c == nil {
+		return log.OtlpResource{}
+	}
+
+	return log.OtlpResource{
+		ServiceName:    c.ServiceName,
+		ServiceVersion: c.GetVersionString(),
+		Environment:    c.ServerType.String(),
+	}
+}
+
+// GetLoggingConfig returns the Logging section of the config, for log.ConfigureFromConfig
+func (c *BaseConfig) GetLoggingConfig() log.Config {
+	if c == nil {
+		return log.Config{}
+	}
+
+	return c.Logging
+}
+
+// GetLoggingServiceDetails returns the details of the service required to configure log sinks, for log.ConfigureFromConfig
+func (c *BaseConfig) GetLoggingServiceDetails() log.ServiceDetails {
+	if c == nil {
+		return log.ServiceDetails{Development: true}
+	}
+
+	webhookClient := NewHttpClientTimeout(30 * time.Second)
+
+	details := log.ServiceDetails{
+		ServiceName:            c.ServiceName,
+		Version:                c.GetVersionString(),
+		ConfigName:             c.ConfigName,
+		Environment:            c.ServerType.String(),
+		Local:                  c.ServerType == Local,
+		Development:            c.ServerType == Local || c.ServerType == Development,
+		GoogleProject:          c.Google.Project,
+		GoogleLogName:          c.Google.LogName,
+		SentryTracesSampleRate: c.SentryTracesSampleRate,
+		HttpClient:             &webhookClient,
+	}
+
+	if c.SentryDsn != nil {
+		details.SentryDsn = *c.SentryDsn
+	}
+
+	return details
+}
+
+// GetLogFields returns fields identifying the service (name, version and
+// environment), e.g., for log.AddProcessor(log.FieldsProcessor(config.GetLogFields()))
+func (c *BaseConfig) GetLogFields() log.Fields {
+	return c.GetLoggingServiceDetails().Fields()
+}
+
 func (c *BaseConfig) IsProductionServer() bool {
 	switch c.ServerType {
 	case Production, LiveTest:
@@ -0,0 +1,200 @@
+// Errors carrying a stack trace, HTTP status, stable error code and a message
+// that is safe to show to users.
+//
+// Errors with the same code match with Is, so sentinel errors can be declared
+// and compared, e.g.,
+//
+//	var ErrOrderNotFound = errors.New(http.StatusNotFound, "order_not_found", "order not found")
+//
+//	if errors.Is(err, ErrOrderNotFound) { ... }
+//
+// This package can be used in place of the standard errors package; Is, As,
+// Unwrap and Join are provided for convenience.
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+)
+
+// Maximum number of stack frames recorded for an error
+const maxStackDepth = 64
+
+// An error with a stack trace, HTTP status, error code and user message
+type Error struct {
+	// Stable, machine-readable error code (e.g., "order_not_found")
+	Code string
+	// HTTP status for responses reporting the error
+	Status int
+	// Message that is safe to show to users; the error message may include internal details
+	UserMessage string
+
+	message string
+	cause   error
+	stack   []uintptr
+}
+
+// New creates an error with the stack of the caller
+//
+// status: HTTP status for responses reporting the error (e.g., http.StatusNotFound)
+// code: stable, machine-readable error code (e.g., "order_not_found")
+// message: internal error message for logs
+func New(status int, code string, message string) *Error {
+	return &Error{Code: code, Status: status, message: message, stack: callers()}
+}
+
+// Wrap wraps an error with the stack of the caller, an HTTP status and error code.
+// The message may be empty, in which case the wrapped error's message is used.
+func Wrap(err error, status int, code string, message string) *Error {
+	return &Error{Code: code, Status: status, message: message, cause: err, stack: callers()}
+}
+
+// WithUserMessage returns a copy of the error with the message that is safe to
+// show to users; the error is not modified, so this can be called on sentinel errors
+func (e *Error) WithUserMessage(userMessage string) *Error {
+	copy := *e
+	copy.UserMessage = userMessage
+	return &copy
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.cause == nil:
+		return e.message
+	case e.message == "":
+		return e.cause.Error()
+	default:
+		return e.message + ": " + e.cause.Error()
+	}
+}
+
+// Unwrap returns the wrapped error, if any
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether the target is an *Error with the same non-empty code,
+// so errors can be compared with a sentinel error of the same code
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && e.Code != "" && e.Code == t.Code
+}
+
+// StackTrace returns the program counters of the stack where the error was
+// created, innermost first.  Sentry reads this to report exception frames.
+func (e *Error) StackTrace() []uintptr {
+	return e.stack
+}
+
+// Format prints the error message, with the code and stack trace for %+v
+func (e *Error) Format(f fmt.State, verb rune) {
+	if verb != 'v' || !f.Flag('+') {
+		fmt.Fprint(f, e.Error())
+		return
+	}
+
+	if e.Code != "" {
+		fmt.Fprintf(f, "[%s] ", e.Code)
+	}
+	fmt.Fprint(f, e.Error())
+	fmt.Fprint(f, FormatStack(e.stack))
+}
+
+// StatusOf returns the HTTP status of the outermost *Error in the chain with a
+// status, or http.StatusInternalServerError if there is none
+func StatusOf(err error) int {
+	var e *Error
+	for errors.As(err, &e) {
+		if e.Status != 0 {
+			return e.Status
+		}
+		err = e.cause
+	}
+
+	return http.StatusInternalServerError
+}
+
+// CodeOf returns the code of the outermost *Error in the chain with a code, or an empty string
+func CodeOf(err error) string {
+	var e *Error
+	for errors.As(err, &e) {
+		if e.Code != "" {
+			return e.Code
+		}
+		err = e.cause
+	}
+
+	return ""
+}
+
+// UserMessageOf returns the user message of the outermost *Error in the chain
+// with a user message, or an empty string
+func UserMessageOf(err error) string {
+	var e *Error
+	for errors.As(err, &e) {
+		if e.UserMessage != "" {
+			return e.UserMessage
+		}
+		err = e.cause
+	}
+
+	return ""
+}
+
+// StackOf returns the stack of the innermost error in the chain with a
+// StackTrace() []uintptr method, i.e., the stack closest to the origin of the
+// error, or nil if there is none
+func StackOf(err error) []uintptr {
+	var stack []uintptr
+
+	for err != nil {
+		if e, ok := err.(interface{ StackTrace() []uintptr }); ok && len(e.StackTrace()) > 0 {
+			stack = e.StackTrace()
+		}
+		err = errors.Unwrap(err)
+	}
+
+	return stack
+}
+
+// FormatStack formats the stack as lines of function, file and line number
+func FormatStack(stack []uintptr) string {
+	if len(stack) == 0 {
+		return ""
+	}
+
+	var builder strings.Builder
+	frames := runtime.CallersFrames(stack)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&builder, "\n%s\n\t%s:%d", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+
+	return builder.String()
+}
+
+// callers returns the stack of the caller of the function calling callers
+func callers() []uintptr {
+	pcs := make([]uintptr, maxStackDepth)
+	// Skip runtime.Callers, callers and the errors package function
+	n := runtime.Callers(3, pcs)
+	return pcs[:n]
+}
+
+// Is reports whether any error in err's chain matches target; see the standard errors.Is
+func Is(err, target error) bool { return errors.Is(err, target) }
+
+// As finds the first error in err's chain that matches target; see the standard errors.As
+func As(err error, target any) bool { return errors.As(err, target) }
+
+// Unwrap returns the result of calling the Unwrap method on err; see the standard errors.Unwrap
+func Unwrap(err error) error { return errors.Unwrap(err) }
+
+// Join returns an error that wraps the given errors; see the standard errors.Join
+func Join(errs ...error) error { return errors.Join(errs...) }
@@ -0,0 +1,56 @@
+package errors
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+var errOrderNotFound = New(http.StatusNotFound, "order_not_found", "order not found")
+
+func findOrder() error {
+	return Wrap(io.EOF, http.StatusNotFound, "order_not_found", "reading order 1234").WithUserMessage("The order could not be found")
+}
+
+func TestWrappedError(t *testing.T) {
+	err := fmt.Errorf("handling request: %w", findOrder())
+
+	if !Is(err, errOrderNotFound) || !Is(err, io.EOF) {
+		t.Error("Is failed to match the sentinel or wrapped error")
+	}
+
+	var serviceErr *Error
+	if !As(err, &serviceErr) || serviceErr.Code != "order_not_found" {
+		t.Error("As failed to find the service error")
+	}
+
+	if err.Error() != "handling request: reading order 1234: EOF" {
+		t.Errorf("unexpected message: %s", err.Error())
+	}
+	if StatusOf(err) != http.StatusNotFound || CodeOf(err) != "order_not_found" || UserMessageOf(err) != "The order could not be found" {
+		t.Errorf("unexpected details: %d %s %s", StatusOf(err), CodeOf(err), UserMessageOf(err))
+	}
+	if StatusOf(io.EOF) != http.StatusInternalServerError {
+		t.Errorf("unexpected status for a plain error: %d", StatusOf(io.EOF))
+	}
+
+	if stack := fmt.Sprintf("%+v", serviceErr); !strings.HasPrefix(stack, "[order_not_found] reading order 1234: EOF\n") || !strings.Contains(stack, "errors.findOrder") {
+		t.Errorf("stack does not start at the origin of the error:\n%s", stack)
+	}
+	if len(StackOf(err)) == 0 {
+		t.Error("StackOf failed to find the stack")
+	}
+}
+
+func TestWithUserMessageCopies(t *testing.T) {
+	err := errOrderNotFound.WithUserMessage("The order could not be found")
+
+	if errOrderNotFound.UserMessage != "" {
+		t.Errorf("sentinel error was modified: %q", errOrderNotFound.UserMessage)
+	}
+	if err.UserMessage != "The order could not be found" || !Is(err, errOrderNotFound) {
+		t.Errorf("unexpected copy: %+v", err)
+	}
+}
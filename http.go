@@ -7,6 +7,8 @@ import (
 	"net"
 	"net/http"
 	"time"
+
+	"github.com/Adapptor/service/v2/errors"
 )
 
 func NewHttpClientTimeout(timeout time.Duration) http.Client {
@@ -24,7 +26,8 @@ func NewHttpClient(timeout time.Duration, insecureSkipVerify bool) http.Client {
 	}
 
 	client := http.Client{
-		Transport: &transport,
+		// Outbound requests are traced if the request context has a Sentry transaction
+		Transport: &SentryTracingTransport{Base: &transport},
 		Timeout:   timeout,
 	}
 
@@ -44,6 +47,39 @@ func WriteHttpError(w http.ResponseWriter, error string, code int) {
 	fmt.Fprintf(w, "%d error : %v", code, error)
 }
 
+// A JSON problem details body describing an error, see RFC 9457
+type ProblemDetails struct {
+	// Short summary of the problem, the HTTP status text
+	Title string `json:"title"`
+	// HTTP status
+	Status int `json:"status"`
+	// Message that is safe to show to users, if available
+	Detail string `json:"detail,omitempty"`
+	// Stable, machine-readable error code, if available
+	Code string `json:"code,omitempty"`
+}
+
+// WriteErrorResponse writes a JSON problem details response for the error,
+// with the status, code and user message of any errors.Error in the error chain.
+//
+// Errors without a status are reported as 500 Internal Server Error.  Only the
+// user message is written; the error message may include internal details.
+func WriteErrorResponse(w http.ResponseWriter, err error) {
+	status := errors.StatusOf(err)
+	problem := ProblemDetails{
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: errors.UserMessageOf(err),
+		Code:   errors.CodeOf(err),
+	}
+
+	js, _ := json.Marshal(problem)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
 func WriteJsonStringResponse(w http.ResponseWriter, statusCode int, str string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
@@ -0,0 +1,569 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Adapptor/service/v2/log"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	DefaultJobVisibilityTimeout = time.Minute
+	DefaultJobMaxRetries        = 5
+	DefaultJobInitialBackoff    = time.Second
+	DefaultJobMaxBackoff        = time.Hour
+	DefaultJobPollInterval      = time.Second
+)
+
+// Error of jobs whose visibility timeout expired on their final attempt
+const jobVisibilityTimeoutError = "visibility timeout expired"
+
+// Error returned when failing an attempt of a job that is no longer active,
+// because its visibility timeout expired and the job was requeued or moved to
+// the dead-letter list
+var ErrJobNotActive = errors.New("queue: job not active")
+
+var (
+	// Adds a job to the ready list, or to the delayed set if delayed
+	enqueueJobScript = redis.NewScript(`
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+local delay = tonumber(ARGV[3])
+if delay > 0 then
+	redis.call("ZADD", KEYS[3], now + delay, ARGV[1])
+else
+	redis.call("LPUSH", KEYS[2], ARGV[1])
+end
+return 1`)
+
+	// Moves due delayed jobs to the ready list, requeues active jobs whose
+	// visibility timeout expired, then pops the next ready job and makes it
+	// active.  Returns {id, data, attempts, last error}, or nil if no job is ready.
+	dequeueJobScript = redis.NewScript(`
+local ready, delayed, active, jobs, attempts, dead, errors = KEYS[1], KEYS[2], KEYS[3], KEYS[4], KEYS[5], KEYS[6], KEYS[7]
+local visibility = tonumber(ARGV[1])
+local maxRetries = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+for _, id in ipairs(redis.call("ZRANGEBYSCORE", delayed, "-inf", now, "LIMIT", 0, 100)) do
+	redis.call("ZREM", delayed, id)
+	redis.call("LPUSH", ready, id)
+end
+
+for _, id in ipairs(redis.call("ZRANGEBYSCORE", active, "-inf", now, "LIMIT", 0, 100)) do
+	redis.call("ZREM", active, id)
+	if tonumber(redis.call("HGET", attempts, id) or "0") > maxRetries then
+		redis.call("HSET", errors, id, ARGV[3])
+		redis.call("LPUSH", dead, id)
+	else
+		-- Retry next
+		redis.call("RPUSH", ready, id)
+	end
+end
+
+while true do
+	local id = redis.call("RPOP", ready)
+	if not id then
+		return nil
+	end
+
+	-- Skip jobs acknowledged after their visibility timeout expired
+	local data = redis.call("HGET", jobs, id)
+	if data then
+		redis.call("ZADD", active, now + visibility, id)
+		local count = redis.call("HINCRBY", attempts, id, 1)
+		return {id, data, count, redis.call("HGET", errors, id) or ""}
+	end
+end`)
+
+	// Removes a completed job
+	ackJobScript = redis.NewScript(`
+redis.call("ZREM", KEYS[1], ARGV[1])
+redis.call("HDEL", KEYS[2], ARGV[1])
+redis.call("HDEL", KEYS[3], ARGV[1])
+redis.call("HDEL", KEYS[4], ARGV[1])
+return 1`)
+
+	// Schedules a retry of a failed job, or moves it to the dead-letter list if
+	// the delay is negative; returns 0 if the attempt is no longer active
+	failJobScript = redis.NewScript(`
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+-- The job may have been redelivered after the visibility timeout of this attempt
+if redis.call("HGET", KEYS[5], ARGV[1]) ~= ARGV[4] or redis.call("ZREM", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+
+redis.call("HSET", KEYS[4], ARGV[1], ARGV[2])
+local delay = tonumber(ARGV[3])
+if delay < 0 then
+	redis.call("LPUSH", KEYS[3], ARGV[1])
+else
+	redis.call("ZADD", KEYS[2], now + delay, ARGV[1])
+end
+return 1`)
+
+	// Moves jobs of the dead-letter list to the ready list with their attempts
+	// reset; returns the number of jobs requeued
+	requeueDeadJobsScript = redis.NewScript(`
+local requeued = 0
+for _, id in ipairs(ARGV) do
+	if redis.call("LREM", KEYS[1], 0, id) > 0 and redis.call("HEXISTS", KEYS[3], id) == 1 then
+		redis.call("HDEL", KEYS[4], id)
+		redis.call("LPUSH", KEYS[2], id)
+		requeued = requeued + 1
+	end
+end
+return requeued`)
+
+	// Removes jobs of the dead-letter list; returns the number of jobs removed
+	deleteDeadJobsScript = redis.NewScript(`
+local deleted = 0
+for _, id in ipairs(ARGV) do
+	if redis.call("LREM", KEYS[1], 0, id) > 0 then
+		redis.call("HDEL", KEYS[2], id)
+		redis.call("HDEL", KEYS[3], id)
+		redis.call("HDEL", KEYS[4], id)
+		deleted = deleted + 1
+	end
+end
+return deleted`)
+)
+
+// Options of a JobQueue; zero values use the defaults
+type JobQueueOptions struct {
+	// Time a dequeued job is hidden from other workers; a job that is not
+	// acknowledged or failed within this time is retried.  Defaults to
+	// DefaultJobVisibilityTimeout.
+	VisibilityTimeout time.Duration
+	// Maximum retries of a failed job before it is moved to the dead-letter list;
+	// defaults to DefaultJobMaxRetries, negative disables retries
+	MaxRetries int
+	// Delay before the first retry, doubled for each retry; defaults to DefaultJobInitialBackoff
+	InitialBackoff time.Duration
+	// Maximum delay between retries; defaults to DefaultJobMaxBackoff
+	MaxBackoff time.Duration
+	// Interval between polls of workers for jobs when the queue is empty;
+	// defaults to DefaultJobPollInterval
+	PollInterval time.Duration
+}
+
+// A job of a JobQueue
+type Job struct {
+	Id         string
+	Payload    []byte
+	EnqueuedAt time.Time
+	// Number of times the job has been dequeued, including this time
+	Attempts int
+	// Error of the previous attempt, if any
+	LastError string
+}
+
+// Json decodes a JSON payload, see JobQueue.EnqueueJson
+func (j *Job) Json(value interface{}) error {
+	return json.Unmarshal(j.Payload, value)
+}
+
+// Protobuf decodes a protocol buffer payload, see JobQueue.EnqueueProtobuf
+func (j *Job) Protobuf(message proto.Message) error {
+	return proto.Unmarshal(j.Payload, message)
+}
+
+// The stored data of a job
+type jobData struct {
+	Payload    []byte    `json:"payload"`
+	EnqueuedAt time.Time `json:"enqueuedAt"`
+}
+
+// A reliable job queue in Redis.  Jobs are delivered at least once: a dequeued
+// job is retried if it is not acknowledged within the visibility timeout, and
+// failed jobs are retried with exponential backoff until they are moved to the
+// dead-letter list.  Dead jobs are kept, with their payloads, until they are
+// requeued with RequeueDead or removed with DeleteDead.
+type JobQueue struct {
+	redis   *Redis
+	name    string
+	options JobQueueOptions
+
+	// Keys share a hash slot on a cluster, for the scripts
+	readyKey    string
+	delayedKey  string
+	activeKey   string
+	jobsKey     string
+	attemptsKey string
+	errorsKey   string
+	deadKey     string
+}
+
+// NewJobQueue creates a job queue
+//
+// name: the name of the queue, used in its Redis keys, e.g., "queue:{emails}:ready"
+func NewJobQueue(redis *Redis, name string, options JobQueueOptions) *JobQueue {
+	if options.VisibilityTimeout <= 0 {
+		options.VisibilityTimeout = DefaultJobVisibilityTimeout
+	}
+	if options.MaxRetries == 0 {
+		options.MaxRetries = DefaultJobMaxRetries
+	} else if options.MaxRetries < 0 {
+		options.MaxRetries = 0
+	}
+	if options.InitialBackoff <= 0 {
+		options.InitialBackoff = DefaultJobInitialBackoff
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = DefaultJobMaxBackoff
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = DefaultJobPollInterval
+	}
+
+	prefix := "queue:{" + name + "}:"
+	return &JobQueue{
+		redis:       redis,
+		name:        name,
+		options:     options,
+		readyKey:    prefix + "ready",
+		delayedKey:  prefix + "delayed",
+		activeKey:   prefix + "active",
+		jobsKey:     prefix + "jobs",
+		attemptsKey: prefix + "attempts",
+		errorsKey:   prefix + "errors",
+		deadKey:     prefix + "dead",
+	}
+}
+
+// Enqueue adds a job with the payload, run after the delay if positive, and
+// returns its ID
+func (q *JobQueue) Enqueue(ctx context.Context, payload []byte, delay time.Duration) (string, error) {
+	id, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(jobData{Payload: payload, EnqueuedAt: time.Now()})
+	if err != nil {
+		return "", err
+	}
+
+	err = enqueueJobScript.Run(ctx, q.redis, []string{q.jobsKey, q.readyKey, q.delayedKey}, id, data, delay.Milliseconds()).Err()
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// EnqueueJson adds a job with the value encoded as JSON, see Job.Json
+func (q *JobQueue) EnqueueJson(ctx context.Context, value interface{}, delay time.Duration) (string, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return q.Enqueue(ctx, payload, delay)
+}
+
+// EnqueueProtobuf adds a job with the protocol buffer, see Job.Protobuf
+func (q *JobQueue) EnqueueProtobuf(ctx context.Context, message proto.Message, delay time.Duration) (string, error) {
+	payload, err := proto.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+	return q.Enqueue(ctx, payload, delay)
+}
+
+// Dequeue returns the next ready job, hidden from other workers for the
+// visibility timeout, or nil if no job is ready.  The job must be acknowledged
+// with Ack, or failed with Fail.
+func (q *JobQueue) Dequeue(ctx context.Context) (*Job, error) {
+	keys := []string{q.readyKey, q.delayedKey, q.activeKey, q.jobsKey, q.attemptsKey, q.deadKey, q.errorsKey}
+	reply, err := dequeueJobScript.Run(ctx, q.redis, keys, q.options.VisibilityTimeout.Milliseconds(), q.options.MaxRetries, jobVisibilityTimeoutError).Slice()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(reply) != 4 {
+		return nil, fmt.Errorf("unexpected dequeue reply %v", reply)
+	}
+
+	id, _ := reply[0].(string)
+	data, _ := reply[1].(string)
+	attempts, _ := reply[2].(int64)
+	lastError, _ := reply[3].(string)
+
+	job := &Job{Id: id, Attempts: int(attempts), LastError: lastError}
+	if err := job.decode(data); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// decode sets the payload and enqueue time from the stored data of the job
+func (j *Job) decode(data string) error {
+	var stored jobData
+	if err := json.Unmarshal([]byte(data), &stored); err != nil {
+		return fmt.Errorf("decoding job %s: %w", j.Id, err)
+	}
+
+	j.Payload = stored.Payload
+	j.EnqueuedAt = stored.EnqueuedAt
+	return nil
+}
+
+// Ack removes a completed job from the queue
+func (q *JobQueue) Ack(ctx context.Context, job *Job) error {
+	return ackJobScript.Run(ctx, q.redis, []string{q.activeKey, q.jobsKey, q.attemptsKey, q.errorsKey}, job.Id).Err()
+}
+
+// Fail records the error of a failed job and schedules a retry with exponential
+// backoff, or moves the job to the dead-letter list if it has no retries left.
+// Returns whether the job was moved to the dead-letter list, or ErrJobNotActive
+// if the visibility timeout of this attempt expired, so the job was already
+// requeued or moved to the dead-letter list.
+func (q *JobQueue) Fail(ctx context.Context, job *Job, jobErr error) (bool, error) {
+	dead := job.Attempts > q.options.MaxRetries
+
+	delay := int64(-1)
+	if !dead {
+		delay = q.backoff(job.Attempts).Milliseconds()
+	}
+
+	message := "unknown error"
+	if jobErr != nil {
+		message = jobErr.Error()
+	}
+
+	keys := []string{q.activeKey, q.delayedKey, q.deadKey, q.errorsKey, q.attemptsKey}
+	failed, err := failJobScript.Run(ctx, q.redis, keys, job.Id, message, delay, job.Attempts).Int64()
+	if err != nil {
+		return false, err
+	}
+	if failed == 0 {
+		return false, ErrJobNotActive
+	}
+
+	return dead, nil
+}
+
+// backoff returns the delay before retrying a job after the given attempts
+func (q *JobQueue) backoff(attempts int) time.Duration {
+	delay := q.options.InitialBackoff
+	for i := 1; i < attempts && delay < q.options.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, q.options.MaxBackoff)
+}
+
+// DeadJobs returns up to count jobs of the dead-letter list, most recent first
+func (q *JobQueue) DeadJobs(ctx context.Context, count int64) ([]*Job, error) {
+	ids, err := q.redis.LRange(ctx, q.deadKey, 0, count-1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	pipe := q.redis.Pipeline()
+	dataCmd := pipe.HMGet(ctx, q.jobsKey, ids...)
+	attemptsCmd := pipe.HMGet(ctx, q.attemptsKey, ids...)
+	errorsCmd := pipe.HMGet(ctx, q.errorsKey, ids...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(ids))
+	for i, id := range ids {
+		data, ok := dataCmd.Val()[i].(string)
+		if !ok {
+			continue
+		}
+
+		job := &Job{Id: id}
+		if attempts, ok := attemptsCmd.Val()[i].(string); ok {
+			job.Attempts, _ = strconv.Atoi(attempts)
+		}
+		job.LastError, _ = errorsCmd.Val()[i].(string)
+		if err := job.decode(data); err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// RequeueDead moves jobs of the dead-letter list back to the ready list, with
+// their attempts reset, and returns the number of jobs requeued
+func (q *JobQueue) RequeueDead(ctx context.Context, ids ...string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	return requeueDeadJobsScript.Run(ctx, q.redis, []string{q.deadKey, q.readyKey, q.jobsKey, q.attemptsKey}, args...).Int64()
+}
+
+// DeleteDead removes jobs of the dead-letter list, and returns the number of
+// jobs removed
+func (q *JobQueue) DeleteDead(ctx context.Context, ids ...string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	return deleteDeadJobsScript.Run(ctx, q.redis, []string{q.deadKey, q.jobsKey, q.attemptsKey, q.errorsKey}, args...).Int64()
+}
+
+// Handles a job; returning an error, or panicking, fails the job
+type JobHandler func(ctx context.Context, job *Job) error
+
+// A pool of workers processing the jobs of a queue, see JobQueue.StartWorkers
+type WorkerPool struct {
+	queue   *JobQueue
+	handler JobHandler
+
+	// Context of jobs, cancelled if shutdown times out
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// StartWorkers starts a pool of workers calling handler for each job, logging
+// the outcome of each job to log.L
+//
+// concurrency: the number of workers
+func (q *JobQueue) StartWorkers(concurrency int, handler JobHandler) *WorkerPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &WorkerPool{
+		queue:   q,
+		handler: handler,
+		ctx:     ctx,
+		cancel:  cancel,
+		stop:    make(chan struct{}),
+	}
+
+	for i := 0; i < concurrency; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+
+	return p
+}
+
+// Shutdown stops the workers dequeuing jobs and waits for jobs in progress to
+// finish.  If the context is done first, the contexts of the jobs in progress
+// are cancelled, and the jobs are retried after their visibility timeout
+// unless they fail.
+func (p *WorkerPool) Shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stop) })
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		return ctx.Err()
+	}
+}
+
+// work processes jobs until the pool is stopped
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+
+		job, err := p.queue.Dequeue(p.ctx)
+		if err != nil {
+			log.L().Log(log.Error, "Failed to dequeue job from queue "+p.queue.name, err, p.ctx)
+		}
+
+		if job == nil {
+			select {
+			case <-p.stop:
+				return
+			case <-time.After(p.queue.options.PollInterval):
+			}
+			continue
+		}
+
+		p.process(job)
+	}
+}
+
+// process handles a job, then acknowledges or fails it
+func (p *WorkerPool) process(job *Job) {
+	ctx := log.WithFields(p.ctx, log.Fields{"queue": p.queue.name, "jobId": job.Id, "attempt": strconv.Itoa(job.Attempts)})
+
+	start := time.Now()
+	err := p.handle(ctx, job)
+	duration := time.Since(start)
+
+	// Record the outcome even if shutdown cancelled the job
+	outcomeCtx := context.WithoutCancel(ctx)
+
+	if err == nil {
+		if ackErr := p.queue.Ack(outcomeCtx, job); ackErr != nil {
+			log.L().Logf(log.Error, ackErr, ctx, "Failed to acknowledge job %s of queue %s", job.Id, p.queue.name)
+			return
+		}
+		log.L().Logf(log.Info, nil, ctx, "Job %s of queue %s succeeded in %v", job.Id, p.queue.name, duration)
+		return
+	}
+
+	dead, failErr := p.queue.Fail(outcomeCtx, job, err)
+	if errors.Is(failErr, ErrJobNotActive) {
+		log.L().Logf(log.Warning, err, ctx, "Job %s of queue %s failed attempt %d after its visibility timeout expired, already requeued", job.Id, p.queue.name, job.Attempts)
+	} else if failErr != nil {
+		log.L().Logf(log.Error, failErr, ctx, "Failed to record failure of job %s of queue %s", job.Id, p.queue.name)
+	} else if dead {
+		log.L().Logf(log.Error, err, ctx, "Job %s of queue %s failed after %d attempts, moved to the dead-letter list", job.Id, p.queue.name, job.Attempts)
+	} else {
+		log.L().Logf(log.Warning, err, ctx, "Job %s of queue %s failed attempt %d, retrying in %v", job.Id, p.queue.name, job.Attempts, p.queue.backoff(job.Attempts))
+	}
+}
+
+// handle calls the handler, recovering from panics
+func (p *WorkerPool) handle(ctx context.Context, job *Job) (err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = newPanicError(value)
+		}
+	}()
+
+	return p.handler(ctx, job)
+}
@@ -0,0 +1,297 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Adapptor/service/v2/log"
+	"github.com/Adapptor/service/v2/log/logtest"
+)
+
+func TestJobQueueEnqueueAck(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	queue := NewJobQueue(r, testKeyPrefix(t, r)+"jobs", JobQueueOptions{})
+
+	type email struct {
+		To string `json:"to"`
+	}
+
+	id, err := queue.EnqueueJson(ctx, email{To: "jo@example.com"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := queue.Dequeue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil || job.Id != id || job.Attempts != 1 {
+		t.Fatalf("expected job %s on its first attempt, got %+v", id, job)
+	}
+
+	var payload email
+	if err := job.Json(&payload); err != nil || payload.To != "jo@example.com" {
+		t.Errorf("unexpected payload %+v, %v", payload, err)
+	}
+
+	if job, err := queue.Dequeue(ctx); job != nil || err != nil {
+		t.Errorf("expected the active job to be hidden, got %+v, %v", job, err)
+	}
+
+	if err := queue.Ack(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := queue.redis.HExists(ctx, queue.jobsKey, id).Result(); exists {
+		t.Error("expected the acknowledged job to be removed")
+	}
+}
+
+func TestJobQueueDelayed(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	queue := NewJobQueue(r, testKeyPrefix(t, r)+"jobs", JobQueueOptions{})
+
+	if _, err := queue.Enqueue(ctx, []byte("later"), 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if job, err := queue.Dequeue(ctx); job != nil || err != nil {
+		t.Fatalf("expected the delayed job not to be ready, got %+v, %v", job, err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	job, err := queue.Dequeue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil || string(job.Payload) != "later" {
+		t.Fatalf("expected the delayed job to be ready, got %+v", job)
+	}
+}
+
+func TestJobQueueVisibilityTimeout(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	queue := NewJobQueue(r, testKeyPrefix(t, r)+"jobs", JobQueueOptions{VisibilityTimeout: 100 * time.Millisecond})
+
+	id, err := queue.Enqueue(ctx, []byte("job"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queue.Dequeue(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	job, err := queue.Dequeue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil || job.Id != id || job.Attempts != 2 {
+		t.Fatalf("expected job %s to be redelivered, got %+v", id, job)
+	}
+}
+
+func TestJobQueueRetriesAndDeadLetters(t *testing.T) {
+	capture := logtest.Capture(t)
+	r := testRedis(t)
+	queue := NewJobQueue(r, testKeyPrefix(t, r)+"jobs", JobQueueOptions{
+		MaxRetries:     2,
+		InitialBackoff: 10 * time.Millisecond,
+		PollInterval:   10 * time.Millisecond,
+	})
+
+	id, err := queue.Enqueue(context.Background(), []byte("job"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls atomic.Int32
+	pool := queue.StartWorkers(2, func(ctx context.Context, job *Job) error {
+		if calls.Add(1) == 3 {
+			panic("third attempt")
+		}
+		return errors.New("flaky")
+	})
+	defer pool.Shutdown(context.Background())
+
+	deadline := time.Now().Add(5 * time.Second)
+	var dead []*Job
+	for len(dead) == 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		if dead, err = queue.DeadJobs(context.Background(), 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(dead) != 1 || dead[0].Id != id || dead[0].Attempts != 3 {
+		t.Fatalf("expected job %s in the dead-letter list after 3 attempts, got %+v", id, dead)
+	}
+	if dead[0].LastError == "" {
+		t.Error("expected the error of the last attempt")
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 attempts, got %d", calls.Load())
+	}
+
+	retry := capture.RequireLogged(t, log.Warning, "failed attempt 1")
+	if retry.Fields["jobId"] != id || retry.Fields["queue"] != queue.name {
+		t.Errorf("expected job fields, got %v", retry.Fields)
+	}
+	capture.RequireLogged(t, log.Error, "moved to the dead-letter list")
+}
+
+func TestWorkerPoolShutdown(t *testing.T) {
+	capture := logtest.Capture(t)
+	r := testRedis(t)
+	queue := NewJobQueue(r, testKeyPrefix(t, r)+"jobs", JobQueueOptions{PollInterval: 10 * time.Millisecond})
+
+	if _, err := queue.Enqueue(context.Background(), []byte("job"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	pool := queue.StartWorkers(1, func(ctx context.Context, job *Job) error {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+
+	<-started
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	capture.RequireLogged(t, log.Info, "succeeded")
+
+	// Jobs still in progress when the shutdown times out are cancelled
+	if _, err := queue.Enqueue(context.Background(), []byte("slow"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	started = make(chan struct{})
+	pool = queue.StartWorkers(1, func(ctx context.Context, job *Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := pool.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the shutdown to time out, got %v", err)
+	}
+
+	// Wait for the cancelled job to be recorded
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	capture.RequireLogged(t, log.Warning, "context canceled")
+}
+
+// Test failing a job after its visibility timeout expired does not record the failure
+func TestJobQueueFailNotActive(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	queue := NewJobQueue(r, testKeyPrefix(t, r)+"jobs", JobQueueOptions{VisibilityTimeout: 50 * time.Millisecond})
+
+	if _, err := queue.Enqueue(ctx, []byte("job"), 0); err != nil {
+		t.Fatal(err)
+	}
+	job, err := queue.Dequeue(ctx)
+	if err != nil || job == nil {
+		t.Fatalf("expected a job, got %+v, %v", job, err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	redelivered, err := queue.Dequeue(ctx)
+	if err != nil || redelivered == nil {
+		t.Fatalf("expected the job to be redelivered, got %+v, %v", redelivered, err)
+	}
+
+	if _, err := queue.Fail(ctx, job, errors.New("slow")); !errors.Is(err, ErrJobNotActive) {
+		t.Errorf("expected ErrJobNotActive, got %v", err)
+	}
+	if delayed, _ := queue.redis.ZCard(ctx, queue.delayedKey).Result(); delayed != 0 {
+		t.Errorf("expected no retry to be scheduled, got %d delayed jobs", delayed)
+	}
+
+	// The redelivered attempt is still active
+	if dead, err := queue.Fail(ctx, redelivered, errors.New("failed")); err != nil || dead {
+		t.Errorf("expected a retry to be scheduled, got %v, %v", dead, err)
+	}
+}
+
+// Test failing a job moved to the dead-letter list after its visibility timeout expired
+func TestJobQueueFailDead(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	queue := NewJobQueue(r, testKeyPrefix(t, r)+"jobs", JobQueueOptions{VisibilityTimeout: 50 * time.Millisecond, MaxRetries: -1})
+
+	if _, err := queue.Enqueue(ctx, []byte("job"), 0); err != nil {
+		t.Fatal(err)
+	}
+	job, err := queue.Dequeue(ctx)
+	if err != nil || job == nil {
+		t.Fatalf("expected a job, got %+v, %v", job, err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if next, err := queue.Dequeue(ctx); next != nil || err != nil {
+		t.Fatalf("expected the job to be moved to the dead-letter list, got %+v, %v", next, err)
+	}
+
+	if _, err := queue.Fail(ctx, job, errors.New("slow")); !errors.Is(err, ErrJobNotActive) {
+		t.Errorf("expected ErrJobNotActive, got %v", err)
+	}
+	if dead, err := queue.DeadJobs(ctx, 10); err != nil || len(dead) != 1 || dead[0].LastError != jobVisibilityTimeoutError {
+		t.Errorf("expected the job to be dead after its visibility timeout, got %+v, %v", dead, err)
+	}
+}
+
+func TestJobQueueRequeueAndDeleteDead(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	queue := NewJobQueue(r, testKeyPrefix(t, r)+"jobs", JobQueueOptions{MaxRetries: -1})
+
+	var ids []string
+	for _, payload := range []string{"requeued", "deleted"} {
+		id, err := queue.Enqueue(ctx, []byte(payload), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+
+		job, err := queue.Dequeue(ctx)
+		if err != nil || job == nil {
+			t.Fatalf("expected a job, got %+v, %v", job, err)
+		}
+		if dead, err := queue.Fail(ctx, job, errors.New("failed")); err != nil || !dead {
+			t.Fatalf("expected the job to be dead, got %v, %v", dead, err)
+		}
+	}
+
+	if requeued, err := queue.RequeueDead(ctx, ids[0], "unknown"); err != nil || requeued != 1 {
+		t.Errorf("expected 1 job to be requeued, got %d, %v", requeued, err)
+	}
+	job, err := queue.Dequeue(ctx)
+	if err != nil || job == nil || job.Id != ids[0] || job.Attempts != 1 {
+		t.Fatalf("expected job %s on its first attempt, got %+v, %v", ids[0], job, err)
+	}
+
+	if deleted, err := queue.DeleteDead(ctx, ids[1]); err != nil || deleted != 1 {
+		t.Errorf("expected 1 job to be deleted, got %d, %v", deleted, err)
+	}
+	if dead, err := queue.DeadJobs(ctx, 10); err != nil || len(dead) != 0 {
+		t.Errorf("expected no dead jobs, got %+v, %v", dead, err)
+	}
+	for _, key := range []string{queue.jobsKey, queue.attemptsKey, queue.errorsKey} {
+		if exists, _ := queue.redis.HExists(ctx, key, ids[1]).Result(); exists {
+			t.Errorf("expected the deleted job to be removed from %s", key)
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Adapptor/service/v2/log"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DefaultLocalCacheMaxBytes = 64 * 1024 * 1024
+	DefaultLocalCacheTTL      = time.Minute
+	// Default pub/sub channel of local cache invalidation messages
+	DefaultLocalCacheChannel = "service:cache:invalidate"
+)
+
+// How other instances are told to drop their local copies of changed keys
+type LocalCacheInvalidation int
+
+const (
+	// Keys changed by the Redis helpers are published to a channel.  Keys changed
+	// by other commands are not invalidated until their local TTL expires.
+	InvalidatePubSub LocalCacheInvalidation = iota
+	// Keyspace notifications of all changes to keys are used; the Redis server
+	// must be configured to send them, e.g., notify-keyspace-events "KA"
+	InvalidateKeyspace
+)
+
+// Options for the local cache, see Redis.EnableLocalCache
+type LocalCacheOptions struct {
+	// Maximum size of the cached keys and values; least recently used values are
+	// evicted.  Defaults to DefaultLocalCacheMaxBytes.
+	MaxBytes int64
+	// Maximum time a value is cached locally, which bounds how stale a value may
+	// be if an invalidation is missed; defaults to DefaultLocalCacheTTL.  Values
+	// are not cached locally after their key expires.
+	TTL time.Duration
+	// Invalidation of local copies of changed keys; defaults to InvalidatePubSub
+	Invalidation LocalCacheInvalidation
+	// Channel of invalidation messages with InvalidatePubSub; defaults to DefaultLocalCacheChannel
+	Channel string
+}
+
+// Hit and miss counts of the local cache and Redis
+type LocalCacheMetrics struct {
+	LocalHits   uint64
+	LocalMisses uint64
+	// Reads from Redis after a local miss
+	RedisHits   uint64
+	RedisMisses uint64
+	// Values evicted to stay within the maximum size
+	Evictions uint64
+	// Number and size of locally cached values
+	Entries int
+	Bytes   int64
+}
+
+type localCacheEntry struct {
+	key     string
+	data    []byte
+	expires time.Time
+	// Expiry of the key in Redis, zero if the key does not expire
+	keyExpires time.Time
+}
+
+// Reads of a key from Redis in progress, and the number of times the key has
+// been invalidated since they started
+type localCacheReads struct {
+	count      int
+	generation uint64
+}
+
+// An in-process LRU cache of Redis values, limited by size and TTL
+type localCache struct {
+	maxBytes     int64
+	ttl          time.Duration
+	invalidation LocalCacheInvalidation
+	channel      string
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	metrics LocalCacheMetrics
+	// Keys being read from Redis, so values read before an invalidation are not
+	// cached after it
+	reads map[string]*localCacheReads
+
+	subscriptions []*redis.PubSub
+}
+
+func newLocalCache(options LocalCacheOptions) *localCache {
+	maxBytes := options.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultLocalCacheMaxBytes
+	}
+
+	ttl := options.TTL
+	if ttl <= 0 {
+		ttl = DefaultLocalCacheTTL
+	}
+
+	channel := options.Channel
+	if channel == "" {
+		channel = DefaultLocalCacheChannel
+	}
+
+	return &localCache{
+		maxBytes:     maxBytes,
+		ttl:          ttl,
+		invalidation: options.Invalidation,
+		channel:      channel,
+		entries:      make(map[string]*list.Element),
+		lru:          list.New(),
+		reads:        make(map[string]*localCacheReads),
+	}
+}
+
+// get returns the locally cached value of a key and the remaining time until
+// the key expires in Redis, negative if the key does not expire
+func (c *localCache) get(key string) ([]byte, time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	element, ok := c.entries[key]
+	if ok && now.After(element.Value.(*localCacheEntry).expires) {
+		c.remove(element)
+		ok = false
+	}
+	if !ok {
+		c.metrics.LocalMisses++
+		return nil, 0, false
+	}
+
+	c.metrics.LocalHits++
+	c.lru.MoveToFront(element)
+
+	entry := element.Value.(*localCacheEntry)
+	remaining := time.Duration(-1)
+	if !entry.keyExpires.IsZero() {
+		remaining = entry.keyExpires.Sub(now)
+	}
+	return entry.data, remaining, true
+}
+
+// startRead starts a read of a key from Redis after a local miss, returning
+// the generation of the key to pass to record
+func (c *localCache) startRead(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reads, ok := c.reads[key]
+	if !ok {
+		reads = &localCacheReads{}
+		c.reads[key] = reads
+	}
+	reads.count++
+
+	return reads.generation
+}
+
+// record records a read from Redis started by startRead, caching the value if
+// it was found and the key has not been invalidated since the read started.
+// The value is cached until the key expires, given by the remaining time read
+// with the value (negative if the key does not expire), if that is sooner than
+// the local TTL.
+func (c *localCache) record(key string, generation uint64, data []byte, remaining time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reads := c.reads[key]
+	reads.count--
+	if reads.count == 0 {
+		delete(c.reads, key)
+	}
+
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			c.metrics.RedisMisses++
+		}
+		return
+	}
+	c.metrics.RedisHits++
+
+	size := int64(len(key) + len(data))
+	if size > c.maxBytes || reads.generation != generation || remaining == 0 {
+		return
+	}
+
+	now := time.Now()
+	entry := &localCacheEntry{key: key, data: data, expires: now.Add(c.ttl)}
+	if remaining > 0 {
+		entry.keyExpires = now.Add(remaining)
+		if entry.keyExpires.Before(entry.expires) {
+			entry.expires = entry.keyExpires
+		}
+	}
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.metrics.Entries++
+	c.metrics.Bytes += size
+
+	for c.metrics.Bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+		c.metrics.Evictions++
+	}
+}
+
+func (c *localCache) delete(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		if element, ok := c.entries[key]; ok {
+			c.remove(element)
+		}
+		if reads, ok := c.reads[key]; ok {
+			reads.generation++
+		}
+	}
+}
+
+// remove removes an element; the lock must be held
+func (c *localCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*localCacheEntry)
+	delete(c.entries, entry.key)
+	c.metrics.Entries--
+	c.metrics.Bytes -= int64(len(entry.key) + len(entry.data))
+}
+
+// EnableLocalCache adds an in-process LRU cache in front of Redis for values
+// read by the helpers (e.g., GetCachedProtobuf, WriteProtobufKey and Cache),
+// dropping local copies when keys change in Redis.  Call it before the client
+// is used.
+func (r *Redis) EnableLocalCache(ctx context.Context, options LocalCacheOptions) error {
+	local := newLocalCache(options)
+
+	subscribe := func(ctx context.Context, client redis.UniversalClient) error {
+		var subscription *redis.PubSub
+		var prefix string
+
+		if local.invalidation == InvalidateKeyspace {
+			db := 0
+			if client, ok := client.(*redis.Client); ok {
+				db = client.Options().DB
+			}
+			prefix = fmt.Sprintf("__keyspace@%d__:", db)
+			subscription = client.PSubscribe(ctx, prefix+"*")
+		} else {
+			subscription = client.Subscribe(ctx, local.channel)
+		}
+
+		// Wait for confirmation of the subscription
+		if _, err := subscription.Receive(ctx); err != nil {
+			subscription.Close()
+			return err
+		}
+
+		local.mu.Lock()
+		local.subscriptions = append(local.subscriptions, subscription)
+		local.mu.Unlock()
+
+		go func() {
+			for message := range subscription.Channel() {
+				if prefix != "" {
+					local.delete(strings.TrimPrefix(message.Channel, prefix))
+				} else {
+					local.delete(strings.Split(message.Payload, "\n")...)
+				}
+			}
+		}()
+
+		return nil
+	}
+
+	var err error
+	if cluster, ok := r.UniversalClient.(*redis.ClusterClient); ok && local.invalidation == InvalidateKeyspace {
+		// Keyspace notifications are only sent to clients of the node of the key
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return subscribe(ctx, client)
+		})
+	} else {
+		err = subscribe(ctx, r.UniversalClient)
+	}
+	if err != nil {
+		local.close()
+		return fmt.Errorf("subscribing to local cache invalidations: %w", err)
+	}
+
+	if previous := r.local.Swap(local); previous != nil {
+		previous.close()
+	}
+	return nil
+}
+
+// DisableLocalCache removes the local cache and its subscriptions
+func (r *Redis) DisableLocalCache() error {
+	local := r.local.Swap(nil)
+	if local == nil {
+		return nil
+	}
+
+	return local.close()
+}
+
+func (c *localCache) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+	for _, subscription := range c.subscriptions {
+		errs = append(errs, subscription.Close())
+	}
+	c.subscriptions = nil
+
+	return errors.Join(errs...)
+}
+
+// LocalCacheMetrics returns the hit and miss counts of the local cache, which
+// are zero if it is not enabled
+func (r *Redis) LocalCacheMetrics() LocalCacheMetrics {
+	local := r.local.Load()
+	if local == nil {
+		return LocalCacheMetrics{}
+	}
+
+	local.mu.Lock()
+	defer local.mu.Unlock()
+	return local.metrics
+}
+
+// Close closes the local cache, if enabled, and the client
+func (r *Redis) Close() error {
+	return errors.Join(r.DisableLocalCache(), r.UniversalClient.Close())
+}
+
+// A read of a key from Redis after a local cache miss
+type localRead struct {
+	local      *localCache
+	key        string
+	generation uint64
+}
+
+// getLocal returns the locally cached value of a key and the remaining time
+// until the key expires.  After a miss, the value read from Redis must be
+// recorded with the returned read, which is nil if the local cache is not
+// enabled.
+func (r *Redis) getLocal(key string) ([]byte, time.Duration, bool, *localRead) {
+	local := r.local.Load()
+	if local == nil {
+		return nil, 0, false, nil
+	}
+
+	if data, remaining, ok := local.get(key); ok {
+		return data, remaining, true, nil
+	}
+
+	return nil, 0, false, &localRead{local: local, key: key, generation: local.startRead(key)}
+}
+
+// record records the value of a key, and the remaining time until the key
+// expires, read from Redis after a local miss
+func (read *localRead) record(data []byte, remaining time.Duration, err error) {
+	if read != nil {
+		read.local.record(read.key, read.generation, data, remaining, err)
+	}
+}
+
+// getBytes returns the value of a key from the local cache, or from Redis
+func (r *Redis) getBytes(ctx context.Context, key string) ([]byte, error) {
+	if r.local.Load() == nil {
+		return r.Get(ctx, key).Bytes()
+	}
+
+	data, _, err := r.getBytesWithTTL(ctx, key)
+	return data, err
+}
+
+// getBytesWithTTL returns the value of a key from the local cache, or from
+// Redis, and the remaining time until the key expires, negative if the key
+// does not expire
+func (r *Redis) getBytesWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	data, remaining, ok, read := r.getLocal(key)
+	if ok {
+		return data, remaining, nil
+	}
+
+	pipe := r.Pipeline()
+	get := pipe.Get(ctx, key)
+	pttl := pipe.PTTL(ctx, key)
+	// Exec returns the first error of the commands, including redis.Nil for a
+	// missing key
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		read.record(nil, 0, err)
+		return nil, 0, err
+	}
+
+	data, err := get.Bytes()
+	remaining = pttl.Val()
+	if remaining == -2 {
+		// The key was deleted after it was read
+		remaining = 0
+	}
+	read.record(data, remaining, err)
+	return data, remaining, err
+}
+
+// invalidateLocal drops the local copies of keys changed by the helpers, and
+// publishes the keys to other instances if invalidating with pub/sub
+func (r *Redis) invalidateLocal(ctx context.Context, keys ...string) {
+	local := r.local.Load()
+	if local == nil || len(keys) == 0 {
+		return
+	}
+
+	local.delete(keys...)
+
+	if local.invalidation == InvalidatePubSub {
+		if err := r.Publish(ctx, local.channel, strings.Join(keys, "\n")).Err(); err != nil {
+			log.Log(log.Warning, "Failed to publish local cache invalidation", err, ctx)
+		}
+	}
+}
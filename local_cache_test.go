@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test a value read before the key is invalidated is not cached after it
+func TestLocalCacheInvalidatedDuringRead(t *testing.T) {
+	local := newLocalCache(LocalCacheOptions{})
+
+	generation := local.startRead("orders:1234")
+	local.delete("orders:1234")
+	local.record("orders:1234", generation, []byte("stale"), -1, nil)
+	if data, _, ok := local.get("orders:1234"); ok {
+		t.Errorf("expected the value read before the invalidation not to be cached, got %q", data)
+	}
+
+	generation = local.startRead("orders:1234")
+	local.delete("orders:5678")
+	local.record("orders:1234", generation, []byte("current"), -1, nil)
+	if data, _, ok := local.get("orders:1234"); !ok || string(data) != "current" {
+		t.Errorf("expected the value to be cached, got %q", data)
+	}
+
+	if len(local.reads) != 0 {
+		t.Errorf("expected finished reads to be removed, got %d", len(local.reads))
+	}
+}
+
+// Test values are not cached locally after their key expires
+func TestLocalCacheKeyExpiry(t *testing.T) {
+	local := newLocalCache(LocalCacheOptions{TTL: time.Minute})
+
+	generation := local.startRead("orders:1234")
+	local.record("orders:1234", generation, []byte("order 1234"), 50*time.Millisecond, nil)
+	if _, remaining, ok := local.get("orders:1234"); !ok || remaining <= 0 || remaining > 50*time.Millisecond {
+		t.Errorf("expected the value to be cached until the key expires, got %v, %v", remaining, ok)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if data, _, ok := local.get("orders:1234"); ok {
+		t.Errorf("expected the value to expire with its key, got %q", data)
+	}
+
+	generation = local.startRead("orders:5678")
+	local.record("orders:5678", generation, []byte("order 5678"), -1, nil)
+	if _, remaining, ok := local.get("orders:5678"); !ok || remaining >= 0 {
+		t.Errorf("expected the value of a key without an expiry to be cached, got %v, %v", remaining, ok)
+	}
+}
+
+// Test keys written by the helpers are invalidated locally and in other instances
+func TestLocalCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	other := testRedis(t)
+	prefix := testKeyPrefix(t, r)
+	for _, r := range []*Redis{r, other} {
+		if err := r.EnableLocalCache(ctx, LocalCacheOptions{Channel: prefix + "invalidate"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := prefix + "time"
+
+	if err := r.RecordTimeKey(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	// Cache the value locally in both instances
+	if _, err := r.getBytes(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	first, err := other.getBytes(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer := r.CacheKeyWriter(ctx, key, time.Minute)
+	fmt.Fprint(writer, "written")
+	if data, err := r.getBytes(ctx, key); err != nil || string(data) != "written" {
+		t.Errorf("expected the written value, got %q, %v", data, err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if data, err := other.getBytes(ctx, key); err == nil && string(data) == "written" {
+			return
+		}
+	}
+	t.Errorf("expected the other instance to drop %q", first)
+}
+
+// Test a failed write does not invalidate the local copy of the key
+func TestLocalCacheFailedWrite(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	prefix := testKeyPrefix(t, r)
+	if err := r.EnableLocalCache(ctx, LocalCacheOptions{Channel: prefix + "invalidate"}); err != nil {
+		t.Fatal(err)
+	}
+	key := prefix + "time"
+
+	// Set the key without publishing an invalidation, which could drop the local copy
+	if err := r.Set(ctx, key, "recorded", time.Minute).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.getBytes(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := r.RecordTimeKey(cancelled, key); err == nil {
+		t.Fatal("expected writing with a cancelled context to fail")
+	}
+	if _, _, ok := r.local.Load().get(key); !ok {
+		t.Error("expected the local copy to be kept after a failed write")
+	}
+}
+
+// Test the local cache can be disabled while it is being read
+func TestDisableLocalCacheConcurrently(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	if err := r.EnableLocalCache(ctx, LocalCacheOptions{Channel: testKeyPrefix(t, r) + "invalidate"}); err != nil {
+		t.Fatal(err)
+	}
+	key := testKeyPrefix(t, r) + "order"
+	if err := r.Set(ctx, key, "order 1234", time.Minute).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if data, err := r.getBytes(ctx, key); err != nil || string(data) != "order 1234" {
+					t.Errorf("unexpected value %q, %v", data, err)
+					return
+				}
+			}
+		}()
+	}
+
+	if err := r.DisableLocalCache(); err != nil {
+		t.Error(err)
+	}
+	wg.Wait()
+}
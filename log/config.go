@@ -0,0 +1,506 @@
+package log
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Log sink types for SinkConfig.Type
+const (
+	SinkStandard    = "standard"
+	SinkFile        = "file"
+	SinkSentry      = "sentry"
+	SinkStackdriver = "stackdriver"
+	SinkSyslog      = "syslog"
+	SinkJournald    = "journald"
+	SinkOtlp        = "otlp"
+	SinkWebhook     = "webhook"
+)
+
+// Time allowed for the sinks of a LoggerSet replaced by ConfigureFromConfig to flush
+const replacedLoggerSetCloseTimeout = 5 * time.Second
+
+// Configuration of all log sinks, e.g., the `Logging` section of a service configuration
+type Config struct {
+	// Default minimum log level of all sinks (e.g., "INFO"); defaults to DEBUG on
+	// local and development servers, otherwise INFO
+	MinimumLevel string
+	// Default user properties to log for all sinks
+	UserPropertiesToLog []UserProperty
+	// Log sinks; if empty, default sinks are configured, see ConfigureFromConfig
+	Sinks []SinkConfig
+	// Whether to add the service fields (see ServiceDetails.Fields) and host
+	// fields (see HostFields) to all entries
+	AddServiceFields bool
+}
+
+// Configuration of a log sink
+type SinkConfig struct {
+	// One of the Sink* types, e.g., "file"
+	Type string
+	// Whether the sink is enabled; by default all sinks are enabled, except remote
+	// sinks (Sentry, Stackdriver and OTLP) on local servers
+	Enabled *bool
+	// Minimum log level (e.g., "WARNING"); defaults to Config.MinimumLevel
+	MinimumLevel string
+	// User properties to log; defaults to Config.UserPropertiesToLog
+	UserPropertiesToLog []UserProperty
+	// Sampling of high volume entries sent to the sink, see SamplingLogger; by
+	// default entries are not sampled
+	Sampling *SamplingConfig
+	// Options specific to the sink type:
+	//
+	// file: filename, maxSizeMegabytes (default 500), maxBackups (default 3), maxAgeDays (default 28), compress, localTime, rotation ("hourly" or "daily"), reopenOnSighup
+	// sentry: dsn (default ServiceDetails.SentryDsn), debug, tracesSampleRate (default ServiceDetails.SentryTracesSampleRate), tags
+	// stackdriver: project and logName (default ServiceDetails.GoogleProject and GoogleLogName)
+	// syslog: network (default "unix"), address (default "/dev/log"), facility (default 1, user), appName (default ServiceDetails.ServiceName)
+	// journald: socket (default DefaultJournaldSocket), identifier (default ServiceDetails.ServiceName)
+	// otlp: protocol (default "grpc"), endpoint (default "localhost:4317"), insecure
+	// webhook: url, template (a text/template with WebhookTemplateFuncs), contentType, batchIntervalSeconds, maxBatchSize, requestsPerMinute, maxRetries; minimum level defaults to ERROR
+	Options map[string]interface{}
+}
+
+// Configuration of the sampling of a log sink; zero values use the defaults of SamplingOptions
+type SamplingConfig struct {
+	IntervalSeconds float64
+	First           int
+	Thereafter      int
+	// Levels to sample (e.g., "DEBUG")
+	Levels []string
+	// Whether occurrences are counted by caller rather than message, see SampleByCaller
+	ByCaller bool
+}
+
+// Options returns the sampling options, or an error if a level is not recognised
+func (c SamplingConfig) Options() (SamplingOptions, error) {
+	options := SamplingOptions{
+		Interval:   time.Duration(c.IntervalSeconds * float64(time.Second)),
+		First:      c.First,
+		Thereafter: c.Thereafter,
+	}
+	if c.ByCaller {
+		options.Key = SampleByCaller
+	}
+	for _, levelString := range c.Levels {
+		level, err := ParseLogLevel(levelString)
+		if err != nil {
+			return options, err
+		}
+		options.Levels = append(options.Levels, level)
+	}
+	return options, nil
+}
+
+// Details of a service required to configure its log sinks
+type ServiceDetails struct {
+	ServiceName string
+	// Version string, e.g., "v1.2.3-production"
+	Version string
+	// Name of the config, used to name the Stackdriver log
+	ConfigName string
+	// Name of the server type, e.g., "Production"
+	Environment string
+	// Whether the service is running on a local server
+	Local bool
+	// Whether the service is running on a local or development server
+	Development bool
+
+	GoogleProject          string
+	GoogleLogName          string
+	SentryDsn              string
+	SentryTracesSampleRate float64
+	// HTTP client for webhook sinks
+	HttpClient *http.Client
+}
+
+// Fields returns fields identifying the service: "service", "version" and "environment"
+func (d ServiceDetails) Fields() Fields {
+	fields := Fields{}
+
+	if d.ServiceName != "" {
+		fields["service"] = d.ServiceName
+	}
+	if d.Version != "" {
+		fields["version"] = d.Version
+	}
+	if d.Environment != "" {
+		fields["environment"] = d.Environment
+	}
+
+	return fields
+}
+
+// A service configuration that includes logging configuration; implemented by service.BaseConfig
+type ConfigProvider interface {
+	GetLoggingConfig() Config
+	GetLoggingServiceDetails() ServiceDetails
+}
+
+// ConfigureFromConfig builds a LoggerSet with the configured log sinks, and
+// replaces the singleton LoggerSet with it.
+//
+// If no sinks are configured, the defaults are the standard logger, Sentry if
+// a Sentry DSN is configured, and Stackdriver if a Google project and log
+// name are configured.  Sentry and Stackdriver are not configured on local
+// servers unless explicitly enabled.
+func ConfigureFromConfig(cfg ConfigProvider) (*LoggerSet, error) {
+	set, err := NewLoggerSetFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Flush and release the sinks of the replaced set, e.g., open files and batching goroutines
+	previous := ReplaceLoggerSet(set)
+	if previous != nil && previous != set {
+		if err := previous.Close(replacedLoggerSetCloseTimeout); err != nil {
+			set.Log(Warning, "Failed to close the replaced log sinks", err, nil)
+		}
+	}
+
+	return set, nil
+}
+
+// NewLoggerSetFromConfig builds a LoggerSet with the configured log sinks, see ConfigureFromConfig
+func NewLoggerSetFromConfig(cfg ConfigProvider) (*LoggerSet, error) {
+	config := cfg.GetLoggingConfig()
+	details := cfg.GetLoggingServiceDetails()
+
+	minimumLevel := Info
+	if config.MinimumLevel != "" {
+		var err error
+		if minimumLevel, err = ParseLogLevel(config.MinimumLevel); err != nil {
+			return nil, fmt.Errorf("configuring logging: %w", err)
+		}
+	} else if details.Development {
+		minimumLevel = Debug
+	}
+
+	sinks := config.Sinks
+	if len(sinks) == 0 {
+		sinks = defaultSinks(details)
+	}
+
+	set := &LoggerSet{minimumLevel: minimumLevel}
+	if len(config.UserPropertiesToLog) > 0 {
+		set.userPropertiesToLog = &config.UserPropertiesToLog
+	}
+
+	if config.AddServiceFields {
+		fields := HostFields()
+		for key, value := range details.Fields() {
+			fields[key] = value
+		}
+		set.AddProcessor(FieldsProcessor(fields))
+	}
+
+	for _, sink := range sinks {
+		if !sink.isEnabled(details) {
+			continue
+		}
+
+		level, err := sink.minimumLevel(minimumLevel)
+		var samplingOptions SamplingOptions
+		if err == nil && sink.Sampling != nil {
+			samplingOptions, err = sink.Sampling.Options()
+		}
+		if err != nil {
+			// Release any sinks already created
+			set.Close(time.Second)
+			return nil, fmt.Errorf("configuring %s log sink: %w", sink.Type, err)
+		}
+
+		logger, err := newSink(sink, details)
+		if err != nil {
+			// Release any sinks already created
+			set.Close(time.Second)
+			return nil, fmt.Errorf("configuring %s log sink: %w", sink.Type, err)
+		}
+		logger.SetMinimumLevel(level)
+
+		if len(sink.UserPropertiesToLog) > 0 {
+			userPropertiesToLog := sink.UserPropertiesToLog
+			logger.SetUserPropertiesToLog(&userPropertiesToLog)
+		} else {
+			logger.SetUserPropertiesToLog(set.userPropertiesToLog)
+		}
+
+		if sink.Sampling != nil {
+			logger = NewSamplingLogger(logger, samplingOptions)
+		}
+
+		set.AddLogger(logger)
+	}
+
+	return set, nil
+}
+
+// defaultSinks returns the default sinks for a service without configured sinks
+func defaultSinks(details ServiceDetails) []SinkConfig {
+	sinks := []SinkConfig{{Type: SinkStandard}}
+
+	if details.SentryDsn != "" {
+		sinks = append(sinks, SinkConfig{Type: SinkSentry})
+	}
+
+	if details.GoogleProject != "" && details.GoogleLogName != "" {
+		sinks = append(sinks, SinkConfig{Type: SinkStackdriver})
+	}
+
+	return sinks
+}
+
+// minimumLevel returns the minimum level of the sink, defaulting to the given
+// level, or an error if the configured level is not recognised
+func (s SinkConfig) minimumLevel(defaultLevel LogLevel) (LogLevel, error) {
+	if s.MinimumLevel != "" {
+		return ParseLogLevel(s.MinimumLevel)
+	}
+
+	if strings.ToLower(s.Type) == SinkWebhook && defaultLevel < Error {
+		// Webhooks are for alerts
+		return Error, nil
+	}
+	return defaultLevel, nil
+}
+
+func (s SinkConfig) isEnabled(details ServiceDetails) bool {
+	if s.Enabled != nil {
+		return *s.Enabled
+	}
+
+	switch strings.ToLower(s.Type) {
+	case SinkSentry, SinkStackdriver, SinkOtlp:
+		return !details.Local
+	}
+
+	return true
+}
+
+// newSink creates a log sink of the configured type, or returns an error if
+// an option has the wrong type
+func newSink(sink SinkConfig, details ServiceDetails) (Logger, error) {
+	options := &sinkOptions{values: sink.Options}
+
+	switch strings.ToLower(sink.Type) {
+	case SinkStandard:
+		return NewStandardLogger(Info), nil
+
+	case SinkFile:
+		filename := options.string("filename", "")
+		rotationName := options.string("rotation", "")
+		fileOptions := FileOptions{
+			MaxSizeMegabytes: options.int("maxSizeMegabytes", 500),
+			MaxBackups:       options.int("maxBackups", 3),
+			MaxAgeDays:       options.int("maxAgeDays", 28),
+			Compress:         options.bool("compress", false),
+			LocalTime:        options.bool("localTime", false),
+			ReopenOnSighup:   options.bool("reopenOnSighup", false),
+		}
+		if options.err != nil {
+			return nil, options.err
+		}
+
+		if filename == "" {
+			return nil, fmt.Errorf("file log sink requires a filename")
+		}
+
+		switch strings.ToLower(rotationName) {
+		case "":
+		case "hourly":
+			fileOptions.Rotation = RotateHourly
+		case "daily":
+			fileOptions.Rotation = RotateDaily
+		default:
+			return nil, fmt.Errorf("unknown file log sink rotation %q", rotationName)
+		}
+
+		return NewFileLoggerWithOptions(filename, Info, fileOptions), nil
+
+	case SinkSentry:
+		var tags *map[string]string
+		if configuredTags, ok := options.values["tags"]; ok && configuredTags != nil {
+			tagsMap, ok := configuredTags.(map[string]interface{})
+			if !ok {
+				return nil, options.typeError("tags", "an object", configuredTags)
+			}
+			tags = &map[string]string{}
+			for key, value := range tagsMap {
+				(*tags)[key] = fmt.Sprintf("%v", value)
+			}
+		} else if details.ServiceName != "" {
+			tags = &map[string]string{"service": details.ServiceName}
+		}
+
+		dsn := options.string("dsn", details.SentryDsn)
+		debug := options.bool("debug", false)
+		tracesSampleRate := options.float("tracesSampleRate", details.SentryTracesSampleRate)
+		if options.err != nil {
+			return nil, options.err
+		}
+
+		return NewSentryLoggerWithTracing(dsn, debug, details.Environment, details.Version, tags, Info, tracesSampleRate)
+
+	case SinkStackdriver:
+		logName := options.string("logName", details.GoogleLogName)
+		project := options.string("project", details.GoogleProject)
+		if options.err != nil {
+			return nil, options.err
+		}
+
+		writer, err := NewStackdriverWriter(details.ConfigName, logName, project)
+		if err != nil {
+			return nil, err
+		}
+		return writer.AsLogger(), nil
+
+	case SinkSyslog:
+		network := options.string("network", "unix")
+		address := options.string("address", "/dev/log")
+		facility := SyslogFacility(options.int("facility", int(FacilityUser)))
+		appName := options.string("appName", details.ServiceName)
+		if options.err != nil {
+			return nil, options.err
+		}
+
+		return NewSyslogLogger(network, address, facility, appName, Info)
+
+	case SinkJournald:
+		socket := options.string("socket", DefaultJournaldSocket)
+		identifier := options.string("identifier", details.ServiceName)
+		if options.err != nil {
+			return nil, options.err
+		}
+
+		return NewJournaldLogger(socket, identifier, Info)
+
+	case SinkOtlp:
+		protocol := OtlpProtocol(options.string("protocol", string(OtlpGrpc)))
+		endpoint := options.string("endpoint", "localhost:4317")
+		insecure := options.bool("insecure", false)
+		if options.err != nil {
+			return nil, options.err
+		}
+
+		return NewOtlpLogger(protocol, endpoint, insecure,
+			OtlpResource{ServiceName: details.ServiceName, ServiceVersion: details.Version, Environment: details.Environment},
+			Info)
+
+	case SinkWebhook:
+		url := options.string("url", "")
+		text := options.string("template", "")
+		webhookOptions := WebhookOptions{
+			ContentType:       options.string("contentType", ""),
+			BatchInterval:     time.Duration(options.float("batchIntervalSeconds", 0) * float64(time.Second)),
+			MaxBatchSize:      options.int("maxBatchSize", 0),
+			RequestsPerMinute: options.float("requestsPerMinute", 0),
+			MaxRetries:        options.int("maxRetries", 0),
+			Client:            details.HttpClient,
+		}
+		if options.err != nil {
+			return nil, options.err
+		}
+
+		if url == "" {
+			return nil, fmt.Errorf("webhook log sink requires a url")
+		}
+
+		if text != "" {
+			webhookTemplate, err := template.New("webhook").Funcs(WebhookTemplateFuncs).Parse(text)
+			if err != nil {
+				return nil, err
+			}
+			webhookOptions.Template = webhookTemplate
+		}
+
+		return NewWebhookLogger(url, Error, webhookOptions), nil
+	}
+
+	return nil, fmt.Errorf("unknown log sink type %s", sink.Type)
+}
+
+// Sink options read from JSON configuration, recording the first option with
+// the wrong type
+type sinkOptions struct {
+	values map[string]interface{}
+	err    error
+}
+
+// typeError records and returns an error for an option with the wrong type
+func (o *sinkOptions) typeError(key string, expected string, value interface{}) error {
+	err := fmt.Errorf("option %s must be %s, got %T %v", key, expected, value, value)
+	if o.err == nil {
+		o.err = err
+	}
+	return err
+}
+
+func (o *sinkOptions) string(key string, defaultValue string) string {
+	value, ok := o.values[key]
+	if !ok || value == nil {
+		return defaultValue
+	}
+
+	stringValue, ok := value.(string)
+	if !ok {
+		o.typeError(key, "a string", value)
+		return defaultValue
+	}
+	if stringValue == "" {
+		return defaultValue
+	}
+	return stringValue
+}
+
+func (o *sinkOptions) int(key string, defaultValue int) int {
+	value, ok := o.values[key]
+	if !ok || value == nil {
+		return defaultValue
+	}
+
+	switch number := value.(type) {
+	case int:
+		return number
+	case float64:
+		// JSON numbers are decoded as float64
+		if number == float64(int(number)) {
+			return int(number)
+		}
+	}
+
+	o.typeError(key, "an integer", value)
+	return defaultValue
+}
+
+func (o *sinkOptions) float(key string, defaultValue float64) float64 {
+	value, ok := o.values[key]
+	if !ok || value == nil {
+		return defaultValue
+	}
+
+	switch number := value.(type) {
+	case float64:
+		return number
+	case int:
+		return float64(number)
+	}
+
+	o.typeError(key, "a number", value)
+	return defaultValue
+}
+
+func (o *sinkOptions) bool(key string, defaultValue bool) bool {
+	value, ok := o.values[key]
+	if !ok || value == nil {
+		return defaultValue
+	}
+
+	boolValue, ok := value.(bool)
+	if !ok {
+		o.typeError(key, "a boolean", value)
+		return defaultValue
+	}
+	return boolValue
+}
@@ -0,0 +1,163 @@
+package log
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Logging Config
+	details ServiceDetails
+}
+
+func (c *testConfig) GetLoggingConfig() Config                 { return c.Logging }
+func (c *testConfig) GetLoggingServiceDetails() ServiceDetails { return c.details }
+
+func TestNewLoggerSetFromConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "service.log")
+
+	var cfg testConfig
+	configJson := `{"Logging": {"MinimumLevel": "WARNING", "UserPropertiesToLog": ["userId"], "Sinks": [
+		{"Type": "standard", "Sampling": {"First": 5, "Levels": ["DEBUG", "INFO"]}},
+		{"Type": "file", "MinimumLevel": "ERROR", "UserPropertiesToLog": ["email"], "Options": {"filename": "` + filename + `", "maxBackups": 5}},
+		{"Type": "sentry", "Options": {"dsn": "https://public@example.com/1"}}
+	]}}`
+	if err := json.Unmarshal([]byte(configJson), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.details = ServiceDetails{ServiceName: "test-service", Local: true, Development: true}
+
+	set, err := NewLoggerSetFromConfig(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close(time.Second)
+
+	// Sentry is disabled on local servers
+	if len(set.loggers) != 2 {
+		t.Fatalf("expected 2 sinks, got %d", len(set.loggers))
+	}
+
+	if level := set.loggers[0].GetMinimumLevel(); level != Warning {
+		t.Errorf("expected standard sink level WARNING, got %s", level)
+	}
+	if userProperties := set.loggers[0].GetUserPropertiesToLog(); userProperties == nil || (*userProperties)[0] != UserPropertyId {
+		t.Errorf("expected standard sink to log the default user properties, got %v", userProperties)
+	}
+
+	if samplingLogger, ok := set.loggers[0].(*SamplingLogger); !ok || samplingLogger.first != 5 || !samplingLogger.levels[Info] {
+		t.Errorf("expected a sampled standard sink, got %T", set.loggers[0])
+	}
+
+	fileLogger, ok := set.loggers[1].(*FileLogger)
+	if !ok {
+		t.Fatalf("expected a file sink, got %T", set.loggers[1])
+	}
+	if level := fileLogger.GetMinimumLevel(); level != Error {
+		t.Errorf("expected file sink level ERROR, got %s", level)
+	}
+	if userProperties := fileLogger.GetUserPropertiesToLog(); userProperties == nil || (*userProperties)[0] != UserPropertyEmail {
+		t.Errorf("expected file sink to log its own user properties, got %v", userProperties)
+	}
+}
+
+func TestNewLoggerSetFromConfigDefaults(t *testing.T) {
+	cfg := testConfig{details: ServiceDetails{ServiceName: "test-service", Local: true, Development: true, SentryDsn: "https://public@example.com/1"}}
+
+	set, err := NewLoggerSetFromConfig(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close(time.Second)
+
+	if len(set.loggers) != 1 {
+		t.Fatalf("expected only the standard sink on a local server, got %d sinks", len(set.loggers))
+	}
+	if level := set.GetMinimumLevel(); level != Debug {
+		t.Errorf("expected level DEBUG on a local server, got %s", level)
+	}
+
+	cfg.Logging.Sinks = []SinkConfig{{Type: "carrier-pigeon"}}
+	if _, err := NewLoggerSetFromConfig(&cfg); err == nil {
+		t.Error("expected an error for an unknown sink type")
+	}
+}
+
+func TestNewLoggerSetFromConfigUnknownLevels(t *testing.T) {
+	for _, logging := range []Config{
+		{MinimumLevel: "WARN"},
+		{Sinks: []SinkConfig{{Type: SinkStandard, MinimumLevel: "warning "}}},
+		{Sinks: []SinkConfig{{Type: SinkStandard, Sampling: &SamplingConfig{Levels: []string{"VERBOSE"}}}}},
+	} {
+		if _, err := NewLoggerSetFromConfig(&testConfig{Logging: logging}); err == nil {
+			t.Errorf("expected an error for the unknown level in %+v", logging)
+		}
+	}
+
+	set, err := NewLoggerSetFromConfig(&testConfig{Logging: Config{MinimumLevel: "warning"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level := set.GetMinimumLevel(); level != Warning {
+		t.Errorf("expected levels to be matched ignoring case, got %s", level)
+	}
+}
+
+func TestNewLoggerSetFromConfigOptionTypes(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "service.log")
+
+	for _, test := range []struct {
+		sink string
+		key  string
+	}{
+		{`{"Type": "file", "Options": {"filename": "` + filename + `", "maxSizeMegabytes": "100"}}`, "maxSizeMegabytes"},
+		{`{"Type": "file", "Options": {"filename": "` + filename + `", "compress": "true"}}`, "compress"},
+		{`{"Type": "file", "Options": {"filename": "` + filename + `", "maxBackups": 2.5}}`, "maxBackups"},
+		{`{"Type": "stackdriver", "Enabled": true, "Options": {"project": 42}}`, "project"},
+		{`{"Type": "syslog", "Options": {"appName": 42}}`, "appName"},
+		{`{"Type": "otlp", "Enabled": true, "Options": {"insecure": "yes"}}`, "insecure"},
+	} {
+		var sink SinkConfig
+		if err := json.Unmarshal([]byte(test.sink), &sink); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := NewLoggerSetFromConfig(&testConfig{Logging: Config{Sinks: []SinkConfig{sink}}})
+		if err == nil || !strings.Contains(err.Error(), sink.Type+" log sink") || !strings.Contains(err.Error(), "option "+test.key+" ") {
+			t.Errorf("expected an error naming the %s sink and option %s, got %v", sink.Type, test.key, err)
+		}
+	}
+}
+
+// A logger that records whether it was closed
+type closeRecordingLogger struct {
+	LoggerThatClosesWithError
+	closed bool
+}
+
+func (l *closeRecordingLogger) Close(timeout time.Duration) error {
+	l.closed = true
+	return nil
+}
+
+func TestConfigureFromConfigClosesReplacedSet(t *testing.T) {
+	logger := &closeRecordingLogger{}
+	replaced := &LoggerSet{}
+	replaced.AddLogger(logger)
+	original := ReplaceLoggerSet(replaced)
+	defer ReplaceLoggerSet(original)
+
+	set, err := ConfigureFromConfig(&testConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if L() != set {
+		t.Error("expected the configured set to replace the singleton")
+	}
+	if !logger.closed {
+		t.Error("expected the sinks of the replaced set to be closed")
+	}
+}
@@ -0,0 +1,167 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	serviceerrors "github.com/Adapptor/service/v2/errors"
+)
+
+// Maximum number of frames of a captured stack
+const maxStackDepth = 64
+
+// Import path of this package, to skip its frames when finding the caller
+var logPackagePath = reflect.TypeOf(Entry{}).PkgPath()
+
+// A log entry
+type Entry struct {
+	Time    time.Time
+	Level   LogLevel
+	Message string
+	Err     error
+	// Context of the entry, with user properties and fields; may be nil
+	Context context.Context
+	// Function, file and line that logged the entry, if known
+	Caller *Caller
+	// Stack from the caller, if captured, see LoggerSet.EnableStackCapture
+	Stack []uintptr
+}
+
+// The location of a function call
+type Caller struct {
+	// Fully qualified function name, e.g., "github.com/acme/api.(*Server).handle"
+	Function string
+	// Full path of the file
+	File string
+	Line int
+}
+
+// String returns the short file name and line, e.g., "server.go:42"
+func (c Caller) String() string {
+	return fmt.Sprintf("%s:%d", c.File[strings.LastIndex(c.File, "/")+1:], c.Line)
+}
+
+// A log sink that accepts complete log entries.  LoggerSet passes entries to
+// sinks implementing EntryLogger with LogEntry instead of Log, so each entry's
+// caller and stack are computed once for all sinks.
+type EntryLogger interface {
+	Logger
+
+	// LogEntry logs the entry, if its level is at or above the minimum level
+	LogEntry(entry *Entry)
+}
+
+// NewEntry creates a log entry, with the first caller outside this package as
+// its caller.
+//
+// skip: number of further frames to skip, e.g., 1 in the Log method of a log
+// sink outside this package, so the caller is not the sink itself
+func NewEntry(level LogLevel, message string, err error, ctx context.Context, skip int) *Entry {
+	return newEntry(level, message, err, ctx, skip, false)
+}
+
+// newEntry creates a log entry, with its caller and optionally its stack
+func newEntry(level LogLevel, message string, err error, ctx context.Context, skip int, captureStack bool) *Entry {
+	entry := &Entry{
+		Time:    time.Now(),
+		Level:   level,
+		Message: message,
+		Err:     err,
+		Context: ctx,
+	}
+
+	entry.Caller, entry.Stack = captureCaller(skip, captureStack)
+
+	return entry
+}
+
+// captureCaller returns the first caller outside this package after skipping
+// the given number of frames, and the stack from that caller if captureStack
+// is true
+func captureCaller(skip int, captureStack bool) (*Caller, []uintptr) {
+	pcs := make([]uintptr, maxStackDepth)
+	// Skip runtime.Callers and captureCaller
+	n := runtime.Callers(2, pcs)
+
+	for i := 0; i < n; i++ {
+		// A program counter has multiple frames if functions were inlined, e.g.,
+		// the package-level Log inlined into its caller
+		frames := runtime.CallersFrames(pcs[i : i+1])
+		for {
+			frame, more := frames.Next()
+			if strings.HasPrefix(frame.Function, "runtime.") {
+				// The entry was logged by a goroutine of this package, e.g., a summary
+				// of a RateLimitedLogger
+				return nil, nil
+			}
+
+			if !isLogPackageFrame(frame) {
+				if skip == 0 {
+					caller := &Caller{Function: frame.Function, File: frame.File, Line: frame.Line}
+					if captureStack {
+						return caller, pcs[i:n]
+					}
+					return caller, nil
+				}
+				skip--
+			}
+
+			if !more {
+				break
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// isLogPackageFrame reports whether the frame is a function of this package,
+// other than a test
+func isLogPackageFrame(frame runtime.Frame) bool {
+	return strings.HasPrefix(frame.Function, logPackagePath+".") && !strings.HasSuffix(frame.File, "_test.go")
+}
+
+// Optional parts of an entry formatted as text
+type entryFormat int
+
+const (
+	formatCaller entryFormat = 1 << iota
+	formatFields
+)
+
+// formatEntry returns the entry as text, as logged by the text log sinks: the
+// caller and fields if included in the format, followed by user properties,
+// error and stack
+func formatEntry(entry *Entry, userPropertiesToLog *[]UserProperty, format entryFormat) string {
+	message := entry.Message
+
+	if entry.Caller != nil && format&formatCaller != 0 {
+		message = fmt.Sprintf("%s: %s", entry.Caller.String(), message)
+	}
+
+	userProperties := GetUserPropertiesString(entry.Context, userPropertiesToLog)
+	if userProperties != nil {
+		message = fmt.Sprintf("%s (%s)", message, *userProperties)
+	}
+
+	if format&formatFields != 0 {
+		fields := GetFieldsString(entry.Context)
+		if fields != nil {
+			message = fmt.Sprintf("%s [%s]", message, *fields)
+		}
+	}
+
+	if entry.Err != nil {
+		message = fmt.Sprintf("%s, %+v", message, entry.Err)
+	}
+
+	if len(entry.Stack) > 0 {
+		message += serviceerrors.FormatStack(entry.Stack)
+	}
+
+	return message
+}
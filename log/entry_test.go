@@ -0,0 +1,111 @@
+package log
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A RecordingLogger that also records complete entries
+type entryRecordingLogger struct {
+	RecordingLogger
+	logEntries []*Entry
+}
+
+func (l *entryRecordingLogger) LogEntry(entry *Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logEntries = append(l.logEntries, entry)
+}
+
+// logViaHelper wraps the log functions, as a service might
+func logViaHelper(set *LoggerSet, message string) {
+	set.Log(Info, message, nil, nil)
+}
+
+func currentLine() int {
+	_, _, line, _ := runtime.Caller(1)
+	return line
+}
+
+func TestLoggerSetCaller(t *testing.T) {
+	recorder := &entryRecordingLogger{}
+	plain := &RecordingLogger{}
+	set := &LoggerSet{}
+	set.AddLogger(recorder)
+	set.AddLogger(plain)
+
+	lines := []int{currentLine() + 1}
+	set.Log(Info, "log", nil, nil)
+	lines = append(lines, currentLine()+1)
+	set.Logf(Info, nil, nil, "log%s", "f")
+	lines = append(lines, currentLine()+1)
+	set.Logln(Info, nil, nil, "log", "ln")
+
+	set.SetCallerSkip(1)
+	lines = append(lines, currentLine()+1)
+	logViaHelper(set, "helper")
+
+	if len(recorder.logEntries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(recorder.logEntries))
+	}
+
+	for i, entry := range recorder.logEntries {
+		if entry.Caller == nil || filepath.Base(entry.Caller.File) != "entry_test.go" || entry.Caller.Line != lines[i] {
+			t.Errorf("expected %q to be logged at entry_test.go:%d, got %v", entry.Message, lines[i], entry.Caller)
+		}
+		if !strings.HasSuffix(entry.Caller.Function, ".TestLoggerSetCaller") {
+			t.Errorf("unexpected caller function %s", entry.Caller.Function)
+		}
+	}
+
+	// Sinks that are not an EntryLogger receive the formatted message
+	if len(plain.entries) != 4 || plain.entries[2].message != "log ln" {
+		t.Errorf("unexpected plain entries %v", plain.entries)
+	}
+}
+
+func TestLoggerSetStackCapture(t *testing.T) {
+	recorder := &entryRecordingLogger{}
+	set := &LoggerSet{}
+	set.AddLogger(recorder)
+	set.EnableStackCapture(Error)
+
+	set.Log(Warning, "no stack", nil, nil)
+	set.Log(Error, "stack", nil, nil)
+
+	if len(recorder.logEntries[0].Stack) != 0 {
+		t.Error("expected no stack below the stack capture level")
+	}
+
+	stack := recorder.logEntries[1].Stack
+	if len(stack) == 0 {
+		t.Fatal("expected a stack at the stack capture level")
+	}
+	if frame, _ := runtime.CallersFrames(stack).Next(); !strings.HasSuffix(frame.Function, ".TestLoggerSetStackCapture") {
+		t.Errorf("expected the stack to start at the caller, got %s", frame.Function)
+	}
+}
+
+func TestFileLoggerCaller(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "service.log")
+	logger := NewFileLogger(filename, Info, 1, 1, 1)
+
+	line := currentLine() + 1
+	logger.Log(Warning, "disk almost full", nil, context.Background())
+	logger.Close(time.Second)
+
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "entry_test.go:" + strconv.Itoa(line) + ": disk almost full"; !strings.Contains(string(contents), expected) {
+		t.Errorf("expected %q in %q", expected, contents)
+	}
+}
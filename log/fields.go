@@ -0,0 +1,77 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Key for the map of all log fields added to a context
+const FieldsKey = "fields"
+
+// Structured key/value pairs logged with every entry for a context (e.g., "orderId" => "1234")
+type Fields map[string]string
+
+// WithFields returns a copy of the context with the given fields added to any
+// fields already present in the context
+func WithFields(ctx context.Context, fields Fields) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	merged := Fields{}
+	for key, value := range GetFields(ctx) {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+
+	return context.WithValue(ctx, FieldsKey, merged)
+}
+
+// WithField returns a copy of the context with the given field added
+func WithField(ctx context.Context, key string, value string) context.Context {
+	return WithFields(ctx, Fields{key: value})
+}
+
+// GetFields returns the fields from the given context, or nil if no fields are found
+func GetFields(ctx context.Context) Fields {
+	if ctx != nil {
+		if fields, ok := ctx.Value(FieldsKey).(Fields); ok {
+			return fields
+		}
+	}
+
+	return nil
+}
+
+// GetFieldsString returns a string of comma-separated key=value pairs, ordered
+// by key, for the fields in the given context.  If the context has no fields,
+// nil is returned.
+func GetFieldsString(ctx context.Context) *string {
+	fields := GetFields(ctx)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	result := fields.String()
+	return &result
+}
+
+// String returns the fields as comma-separated key=value pairs, ordered by key
+func (f Fields) String() string {
+	keys := make([]string, 0, len(f))
+	for key := range f {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, f[key]))
+	}
+
+	return strings.Join(pairs, ", ")
+}
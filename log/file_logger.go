@@ -9,32 +9,79 @@ import (
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+// Time-based rotation of a FileLogger
+type FileRotation int
+
+const (
+	// Rotate by size only
+	RotateNever FileRotation = iota
+	// Rotate at the start of each hour
+	RotateHourly
+	// Rotate at midnight
+	RotateDaily
+)
+
+// Options of a FileLogger
+type FileOptions struct {
+	// Maximum size of the log file before it is rotated; defaults to 100 megabytes
+	MaxSizeMegabytes int
+	// Maximum number of rotated files to retain; zero retains all files
+	MaxBackups int
+	// Maximum age of rotated files to retain; zero retains all files
+	MaxAgeDays int
+	// Whether to gzip rotated files
+	Compress bool
+	// Whether to name rotated files and rotate daily at midnight in local time,
+	// rather than UTC
+	LocalTime bool
+	// Time-based rotation, in addition to rotation by size
+	Rotation FileRotation
+	// Whether to reopen the log file on SIGHUP, for rotation by an external tool
+	// such as logrotate
+	ReopenOnSighup bool
+	// Called in a new goroutine with the path of each file rotated by the logger,
+	// e.g., to upload it; the path is of the gzipped file if Compress is true
+	PostRotate func(filename string)
+}
+
 // A rolling file logger
 type FileLogger struct {
 	minimumLevel        LogLevel
-	lumberjackLogger    *lumberjack.Logger
+	writer              *rotatingWriter
 	levelLoggers        map[LogLevel]*log.Logger
 	userPropertiesToLog *[]UserProperty
 }
 
 func NewFileLogger(filename string, minimumLevel LogLevel, maximumSizeMegabytes int, maximumRetainedLogFilesCount int, maximumRetainedLogFilesAgeDays int) *FileLogger {
-	lumberjackLogger := &lumberjack.Logger{
+	return NewFileLoggerWithOptions(filename, minimumLevel, FileOptions{
+		MaxSizeMegabytes: maximumSizeMegabytes,
+		MaxBackups:       maximumRetainedLogFilesCount,
+		MaxAgeDays:       maximumRetainedLogFilesAgeDays,
+	})
+}
+
+// NewFileLoggerWithOptions creates a file logger with compression, time-based
+// rotation or a post-rotate hook
+func NewFileLoggerWithOptions(filename string, minimumLevel LogLevel, options FileOptions) *FileLogger {
+	writer := newRotatingWriter(&lumberjack.Logger{
 		Filename:   filename,
-		MaxSize:    maximumSizeMegabytes,
-		MaxBackups: maximumRetainedLogFilesCount,
-		MaxAge:     maximumRetainedLogFilesAgeDays,
-	}
+		MaxSize:    options.MaxSizeMegabytes,
+		MaxBackups: options.MaxBackups,
+		MaxAge:     options.MaxAgeDays,
+		Compress:   options.Compress,
+		LocalTime:  options.LocalTime,
+	}, options)
 
 	levelLoggers := make(map[LogLevel]*log.Logger)
 	for _, logLevel := range LogLevels {
-		levelLoggers[logLevel] = log.New(lumberjackLogger, fmt.Sprintf("%s: ", logLevel.String()), log.Ldate|log.Ltime|log.Lshortfile)
+		levelLoggers[logLevel] = log.New(writer, fmt.Sprintf("%s: ", logLevel.String()), log.Ldate|log.Ltime)
 	}
 
 	return &FileLogger{
 		minimumLevel: minimumLevel,
 		// Wrap the lumberjack logger with go standard loggers to get decorations consistent with the StandardLogger
-		levelLoggers:     levelLoggers,
-		lumberjackLogger: lumberjackLogger,
+		levelLoggers: levelLoggers,
+		writer:       writer,
 	}
 }
 
@@ -54,16 +101,14 @@ func (l *FileLogger) GetUserPropertiesToLog() *[]UserProperty { return l.userPro
 
 func (l *FileLogger) Log(level LogLevel, message string, err error, ctx context.Context) {
 	if level >= l.minimumLevel {
-		userProperties := GetUserPropertiesString(ctx, l.userPropertiesToLog)
-		if userProperties != nil {
-			message = fmt.Sprintf("%s (%s)", message, *userProperties)
-		}
+		l.LogEntry(newEntry(level, message, err, ctx, 0, false))
+	}
+}
 
-		if err == nil {
-			l.levelLoggers[level].Println(message)
-		} else {
-			l.levelLoggers[level].Printf("%s, %+v\n", message, err)
-		}
+// Logs the entry prefixed with its caller's file and line
+func (l *FileLogger) LogEntry(entry *Entry) {
+	if entry.Level >= l.minimumLevel {
+		l.levelLoggers[entry.Level].Println(formatEntry(entry, l.userPropertiesToLog, formatCaller|formatFields))
 	}
 }
 
@@ -86,6 +131,17 @@ func (l *FileLogger) Logln(level LogLevel, err error, ctx context.Context, args
 	}
 }
 
+// Rotate rotates the log file immediately
+func (l *FileLogger) Rotate() error {
+	return l.writer.Rotate()
+}
+
+// Reopen closes the log file, so it is reopened by the next entry, e.g., after
+// it was moved by an external tool
+func (l *FileLogger) Reopen() error {
+	return l.writer.Reopen()
+}
+
 func (l *FileLogger) Close(timeout time.Duration) error {
-	return l.lumberjackLogger.Close()
+	return l.writer.Close()
 }
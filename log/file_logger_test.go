@@ -0,0 +1,167 @@
+package log
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileLoggerDailyRotation(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "service.log")
+	rotated := make(chan string, 1)
+	logger := NewFileLoggerWithOptions(filename, Info, FileOptions{
+		Compress:   true,
+		Rotation:   RotateDaily,
+		PostRotate: func(filename string) { rotated <- filename },
+	})
+	defer logger.Close(time.Second)
+
+	now := time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)
+	logger.writer.now = func() time.Time { return now }
+
+	logger.Log(Info, "before midnight", nil, context.Background())
+	now = now.Add(time.Minute)
+	logger.Log(Info, "after midnight", nil, context.Background())
+
+	var backup string
+	select {
+	case backup = <-rotated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the post-rotate hook to be called")
+	}
+
+	if !strings.HasSuffix(backup, ".log.gz") {
+		t.Fatalf("expected a compressed backup, got %s", backup)
+	}
+
+	file, err := os.Open(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(contents), "before midnight") || strings.Contains(string(contents), "after midnight") {
+		t.Errorf("unexpected backup contents %q", contents)
+	}
+
+	contents, err = os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(contents), "after midnight") || strings.Contains(string(contents), "before midnight") {
+		t.Errorf("unexpected log file contents %q", contents)
+	}
+}
+
+func TestFileLoggerReopen(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "service.log")
+	logger := NewFileLogger(filename, Info, 1, 1, 1)
+	defer logger.Close(time.Second)
+
+	logger.Log(Info, "first", nil, context.Background())
+
+	// Rotate externally, as logrotate would
+	moved := filepath.Join(dir, "service.log.1")
+	if err := os.Rename(filename, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Log(Info, "second", nil, context.Background())
+
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(contents), "second") || strings.Contains(string(contents), "first") {
+		t.Errorf("unexpected log file contents %q", contents)
+	}
+}
+
+// Test only files rotated by lumberjack are backups, and compressed backups match their uncompressed name
+func TestRotatingWriterBackups(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"service.log",
+		"service-access.log",
+		"service-2026-03-01T23-59-00.000.log",
+		"service-2026-02-28T23-59-00.000.log.gz",
+		"service-2026-02-27T23-59-00.000.txt",
+		"other-2026-03-01T23-59-00.000.log",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logger := NewFileLoggerWithOptions(filepath.Join(dir, "service.log"), Info, FileOptions{})
+	defer logger.Close(time.Second)
+
+	backups := logger.writer.backups()
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups, got %v", backups)
+	}
+
+	compressed, ok := backups[filepath.Join(dir, "service-2026-02-28T23-59-00.000.log")]
+	if !ok || compressed.path != filepath.Join(dir, "service-2026-02-28T23-59-00.000.log.gz") || !compressed.rotated.Equal(time.Date(2026, 2, 28, 23, 59, 0, 0, time.UTC)) {
+		t.Errorf("unexpected compressed backup %+v", compressed)
+	}
+}
+
+// Test the post-rotate hook is called once per rotation, ignoring other files
+func TestFileLoggerPostRotateOnce(t *testing.T) {
+	dir := t.TempDir()
+	rotated := make(chan string, 10)
+	logger := NewFileLoggerWithOptions(filepath.Join(dir, "service.log"), Info, FileOptions{
+		PostRotate: func(filename string) { rotated <- filename },
+	})
+	defer logger.Close(time.Second)
+
+	for i := 0; i < 2; i++ {
+		logger.Log(Info, "entry", nil, context.Background())
+		// A file of another log, created between rotations
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("service-access%d.log", i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := logger.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	var backups []string
+	for len(backups) < 2 {
+		select {
+		case backup := <-rotated:
+			backups = append(backups, backup)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected 2 rotations, got %v", backups)
+		}
+	}
+
+	select {
+	case backup := <-rotated:
+		t.Errorf("unexpected post-rotate call for %s", backup)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if backups[0] == backups[1] || strings.Contains(backups[0], "access") || strings.Contains(backups[1], "access") {
+		t.Errorf("unexpected backups %v", backups)
+	}
+}
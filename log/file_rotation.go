@@ -0,0 +1,250 @@
+package log
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	// Default maximum size of a log file, as for lumberjack
+	defaultFileMaxSizeMegabytes = 100
+	// Suffix of files compressed by lumberjack
+	compressedFileSuffix = ".gz"
+	// Maximum time to wait for lumberjack to compress a rotated file
+	compressTimeout = time.Minute
+	// Format of the rotation time in the names of rotated files, as for lumberjack
+	backupTimeFormat = "2006-01-02T15-04-05.000"
+)
+
+// A lumberjack logger that also rotates by time and calls a hook after each
+// rotation.  The writer rotates the file itself before lumberjack would, so it
+// knows of every rotation.
+type rotatingWriter struct {
+	mu       sync.Mutex
+	logger   *lumberjack.Logger
+	options  FileOptions
+	maxBytes int64
+	// Whether the size and period of the current file are known
+	opened bool
+	size   int64
+	// Start of the rotation period of the current file
+	period time.Time
+	now    func() time.Time
+
+	signals   chan os.Signal
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newRotatingWriter(logger *lumberjack.Logger, options FileOptions) *rotatingWriter {
+	maxSizeMegabytes := options.MaxSizeMegabytes
+	if maxSizeMegabytes <= 0 {
+		maxSizeMegabytes = defaultFileMaxSizeMegabytes
+	}
+
+	w := &rotatingWriter{
+		logger:   logger,
+		options:  options,
+		maxBytes: int64(maxSizeMegabytes) * 1024 * 1024,
+		now:      time.Now,
+	}
+
+	if options.ReopenOnSighup {
+		w.signals = make(chan os.Signal, 1)
+		w.done = make(chan struct{})
+		signal.Notify(w.signals, syscall.SIGHUP)
+		go w.handleSignals()
+	}
+
+	return w
+}
+
+func (w *rotatingWriter) handleSignals() {
+	for {
+		select {
+		case <-w.signals:
+			w.Reopen()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	if !w.opened {
+		w.open(now)
+	}
+
+	if w.size > 0 {
+		expired := w.options.Rotation != RotateNever && !w.periodStart(now).Equal(w.period)
+		if expired || w.size+int64(len(p)) >= w.maxBytes {
+			if err := w.rotate(now); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	n, err := w.logger.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// open reads the size and rotation period of an existing log file
+func (w *rotatingWriter) open(now time.Time) {
+	w.opened = true
+	if info, err := os.Stat(w.logger.Filename); err == nil {
+		w.size = info.Size()
+		w.period = w.periodStart(info.ModTime())
+	} else {
+		w.size = 0
+		w.period = w.periodStart(now)
+	}
+}
+
+// periodStart returns the start of the rotation period containing the time
+func (w *rotatingWriter) periodStart(t time.Time) time.Time {
+	if !w.options.LocalTime {
+		t = t.UTC()
+	}
+
+	switch w.options.Rotation {
+	case RotateHourly:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case RotateDaily:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+	return time.Time{}
+}
+
+// Rotate rotates the log file immediately
+func (w *rotatingWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.rotate(w.now())
+}
+
+func (w *rotatingWriter) rotate(now time.Time) error {
+	var previous map[string]backupFile
+	var start time.Time
+	if w.options.PostRotate != nil {
+		previous = w.backups()
+		// Lumberjack names the rotated file with the time truncated to milliseconds
+		start = time.Now().Truncate(time.Millisecond)
+	}
+
+	err := w.logger.Rotate()
+	w.opened = true
+	w.size = 0
+	w.period = w.periodStart(now)
+
+	if w.options.PostRotate != nil {
+		end := time.Now()
+		for name, backup := range w.backups() {
+			// Files rotated earlier may have been compressed since they were listed
+			if _, ok := previous[name]; !ok && !backup.rotated.Before(start) && !backup.rotated.After(end) {
+				go w.postRotate(backup.path)
+			}
+		}
+	}
+
+	return err
+}
+
+// A rotated log file
+type backupFile struct {
+	// Path of the file, which is compressed if it has the compressed suffix
+	path string
+	// Time of the rotation, from the file name
+	rotated time.Time
+}
+
+// backups returns the rotated log files, keyed by their path without the
+// compressed suffix.  Lumberjack names rotated files as the log file name with
+// the rotation time before the extension, e.g., "service-2026-03-01T23-59-00.000.log".
+func (w *rotatingWriter) backups() map[string]backupFile {
+	backups := map[string]backupFile{}
+
+	dir := filepath.Dir(w.logger.Filename)
+	name := filepath.Base(w.logger.Filename)
+	ext := filepath.Ext(name)
+	prefix := name[:len(name)-len(ext)] + "-"
+
+	location := time.UTC
+	if w.options.LocalTime {
+		location = time.Local
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return backups
+	}
+
+	for _, file := range files {
+		uncompressed := strings.TrimSuffix(file.Name(), compressedFileSuffix)
+		if file.IsDir() || len(uncompressed) < len(prefix)+len(ext) || !strings.HasPrefix(uncompressed, prefix) || !strings.HasSuffix(uncompressed, ext) {
+			continue
+		}
+
+		// Other files with the same prefix (e.g., "service-access.log") have no rotation time
+		rotated, err := time.ParseInLocation(backupTimeFormat, uncompressed[len(prefix):len(uncompressed)-len(ext)], location)
+		if err != nil {
+			continue
+		}
+
+		backups[filepath.Join(dir, uncompressed)] = backupFile{path: filepath.Join(dir, file.Name()), rotated: rotated}
+	}
+
+	return backups
+}
+
+// postRotate calls the post-rotate hook, after lumberjack has compressed the
+// rotated file if compression is enabled
+func (w *rotatingWriter) postRotate(filename string) {
+	if w.options.Compress && !strings.HasSuffix(filename, compressedFileSuffix) {
+		for deadline := time.Now().Add(compressTimeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+			if _, err := os.Stat(filename); os.IsNotExist(err) {
+				if _, err := os.Stat(filename + compressedFileSuffix); err == nil {
+					filename += compressedFileSuffix
+				}
+				break
+			}
+		}
+	}
+
+	w.options.PostRotate(filename)
+}
+
+// Reopen closes the log file, so it is reopened by the next write
+func (w *rotatingWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.opened = false
+	return w.logger.Close()
+}
+
+func (w *rotatingWriter) Close() error {
+	w.closeOnce.Do(func() {
+		if w.signals != nil {
+			signal.Stop(w.signals)
+			close(w.done)
+		}
+	})
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.logger.Close()
+}
@@ -0,0 +1,177 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Path of the local journald native protocol socket
+const DefaultJournaldSocket = "/run/systemd/journal/socket"
+
+// A logger that writes to the local systemd journal using the journald native protocol
+//
+// The PRIORITY journal field is mapped from the log level, and fields are
+// written as journal fields, with names converted to the journal's upper case
+// format (e.g., "orderId" => "ORDERID").
+type JournaldLogger struct {
+	identifier string
+
+	mu                  sync.Mutex
+	conn                *net.UnixConn
+	socketAddress       *net.UnixAddr
+	minimumLevel        LogLevel
+	userPropertiesToLog *[]UserProperty
+}
+
+// NewJournaldLogger creates a logger that writes to the journald socket
+//
+// socketPath: the journald socket, usually DefaultJournaldSocket
+// identifier: the SYSLOG_IDENTIFIER of all entries (e.g., the service name)
+// minimumLevel: minimum log level
+func NewJournaldLogger(socketPath string, identifier string, minimumLevel LogLevel) (*JournaldLogger, error) {
+	if _, err := os.Stat(socketPath); err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	return &JournaldLogger{
+		identifier:    identifier,
+		conn:          conn,
+		socketAddress: &net.UnixAddr{Name: socketPath, Net: "unixgram"},
+		minimumLevel:  minimumLevel,
+	}, nil
+}
+
+func (l *JournaldLogger) SetMinimumLevel(level LogLevel) {
+	l.minimumLevel = level
+}
+
+func (l *JournaldLogger) GetMinimumLevel() LogLevel {
+	return l.minimumLevel
+}
+
+func (l *JournaldLogger) SetUserPropertiesToLog(userPropertiesToLog *[]UserProperty) {
+	l.userPropertiesToLog = userPropertiesToLog
+}
+
+func (l *JournaldLogger) GetUserPropertiesToLog() *[]UserProperty { return l.userPropertiesToLog }
+
+func (l *JournaldLogger) Log(level LogLevel, message string, err error, ctx context.Context) {
+	if level >= l.minimumLevel {
+		l.LogEntry(newEntry(level, message, err, ctx, 0, false))
+	}
+}
+
+// Writes the entry, with its caller as the CODE_FILE, CODE_LINE and CODE_FUNC journal fields
+func (l *JournaldLogger) LogEntry(entry *Entry) {
+	if entry.Level >= l.minimumLevel {
+		var buffer bytes.Buffer
+		for key, value := range GetFields(entry.Context) {
+			if name := journalFieldName(key); name != "" && !journalReservedFields[name] {
+				writeJournalField(&buffer, name, value)
+			}
+		}
+		if entry.Caller != nil {
+			writeJournalField(&buffer, "CODE_FILE", entry.Caller.File)
+			writeJournalField(&buffer, "CODE_LINE", fmt.Sprintf("%d", entry.Caller.Line))
+			writeJournalField(&buffer, "CODE_FUNC", entry.Caller.Function)
+		}
+		writeJournalField(&buffer, "PRIORITY", fmt.Sprintf("%d", logLevelToSyslogSeverity[entry.Level]))
+		writeJournalField(&buffer, "SYSLOG_IDENTIFIER", l.identifier)
+		writeJournalField(&buffer, "MESSAGE", formatEntry(entry, l.userPropertiesToLog, 0))
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if l.conn == nil {
+			return
+		}
+
+		if _, err := l.conn.WriteToUnix(buffer.Bytes(), l.socketAddress); err != nil {
+			ReportSinkError("journald", fmt.Errorf("failed to write: %w", err))
+		}
+	}
+}
+
+func (l *JournaldLogger) Logf(level LogLevel, err error, ctx context.Context, format string, args ...interface{}) {
+	if level >= l.minimumLevel {
+		l.Log(level, fmt.Sprintf(format, args...), err, ctx)
+	}
+}
+
+func (l *JournaldLogger) Logln(level LogLevel, err error, ctx context.Context, args ...interface{}) {
+	if level >= l.minimumLevel {
+		l.Log(level, strings.TrimSuffix(fmt.Sprintln(args...), "\n"), err, ctx)
+	}
+}
+
+func (l *JournaldLogger) Close(timeout time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return nil
+	}
+
+	err := l.conn.Close()
+	l.conn = nil
+	return err
+}
+
+// Journal fields written by JournaldLogger, which fields may not replace
+var journalReservedFields = map[string]bool{
+	"PRIORITY":          true,
+	"SYSLOG_IDENTIFIER": true,
+	"MESSAGE":           true,
+	"CODE_FILE":         true,
+	"CODE_LINE":         true,
+	"CODE_FUNC":         true,
+}
+
+// writeJournalField writes a field in the journald native protocol format;
+// values containing newlines are written with an explicit length
+func writeJournalField(buffer *bytes.Buffer, name string, value string) {
+	if !strings.Contains(value, "\n") {
+		buffer.WriteString(name + "=" + value + "\n")
+		return
+	}
+
+	buffer.WriteString(name + "\n")
+	binary.Write(buffer, binary.LittleEndian, uint64(len(value)))
+	buffer.WriteString(value + "\n")
+}
+
+// journalFieldName converts a field name to a valid journal field name of upper
+// case letters, digits and underscores, not starting with an underscore or
+// digit, or returns an empty string if there is no valid name
+func journalFieldName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+
+	// Fields starting with an underscore are trusted fields set by journald
+	name = strings.TrimLeft(name, "_0123456789")
+
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
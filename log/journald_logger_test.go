@@ -0,0 +1,43 @@
+package log
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestJournaldLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.sock")
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	logger, err := NewJournaldLogger(path, "test-service", Info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close(time.Second)
+
+	ctx := WithField(context.Background(), "orderId", "1234")
+	_, file, line, _ := runtime.Caller(0)
+	logger.Log(Warning, "payment failed\nretrying", nil, ctx)
+	line++
+
+	buffer := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "ORDERID=1234\nCODE_FILE=" + file + "\nCODE_LINE=" + strconv.Itoa(line) + "\nCODE_FUNC=github.com/Adapptor/service/v2/log.TestJournaldLogger\nPRIORITY=4\nSYSLOG_IDENTIFIER=test-service\nMESSAGE\n\x17\x00\x00\x00\x00\x00\x00\x00payment failed\nretrying\n"
+	if actual := string(buffer[:n]); actual != expected {
+		t.Errorf("unexpected journal entry: %q", actual)
+	}
+}
@@ -1,6 +1,9 @@
 package log
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
 // A log severity level
 type LogLevel int
@@ -47,3 +50,15 @@ func GetLogLevel(level string) LogLevel {
 		return Info
 	}
 }
+
+// ParseLogLevel returns the LogLevel that matches the given log level string
+// (e.g., "WARNING"), ignoring case, or an error if there is none
+func ParseLogLevel(level string) (LogLevel, error) {
+	for i, levelString := range LogLevelStrings {
+		if strings.EqualFold(level, levelString) {
+			return LogLevels[i], nil
+		}
+	}
+
+	return Info, fmt.Errorf("unknown log level %q", level)
+}
@@ -4,16 +4,27 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
+	"os"
+	"sync/atomic"
 	"time"
 )
 
-// Singleton LoggerSet
-var loggerSet = NewLoggerSet(Info)
+// Singleton LoggerSet, read by L and the package-level log functions
+var current atomic.Pointer[LoggerSet]
 
-// A public reference to the singleton LoggerSet if required for injection
-var L = loggerSet
+func init() {
+	current.Store(NewLoggerSet(Info))
+}
+
+// L returns the singleton LoggerSet if required for injection.  It returns
+// the LoggerSet most recently set by ReplaceLoggerSet, so call L when logging
+// rather than keeping the result.
+func L() *LoggerSet {
+	return current.Load()
+}
 
 // A simple logging interface
 type Logger interface {
@@ -47,8 +58,15 @@ type Logger interface {
 	Close(timeout time.Duration) error
 }
 
+// ReplaceLoggerSet replaces the singleton LoggerSet returned by L and used by
+// the package-level log functions, returning the previous LoggerSet so it can
+// be restored.  It may be called concurrently with logging.
+func ReplaceLoggerSet(set *LoggerSet) *LoggerSet {
+	return current.Swap(set)
+}
+
 func AddLogger(logger Logger) {
-	loggerSet.AddLogger(logger)
+	L().AddLogger(logger)
 }
 
 // Convenience function to set the minimum log level for all
@@ -56,7 +74,7 @@ func AddLogger(logger Logger) {
 //
 // Note: Log sinks added after this call will not be affected
 func SetMinimumLevel(logLevel LogLevel) {
-	loggerSet.SetMinimumLevel(logLevel)
+	L().SetMinimumLevel(logLevel)
 }
 
 // Convenience function to get the minimum log level for all
@@ -65,7 +83,7 @@ func SetMinimumLevel(logLevel LogLevel) {
 // Note: Log sinks added after the most recent call of SetMinimumLevel
 // can have different minimum log levels.
 func GetMinimumLevel() LogLevel {
-	return loggerSet.GetMinimumLevel()
+	return L().GetMinimumLevel()
 }
 
 // Convenience function to set the user properties to log for all
@@ -73,9 +91,9 @@ func GetMinimumLevel() LogLevel {
 //
 // Note: Log sinks added after this call will not be affected
 func SetUserPropertiesToLog(userPropertiesToLog *[]UserProperty) {
-	loggerSet.userPropertiesToLog = userPropertiesToLog
+	L().userPropertiesToLog = userPropertiesToLog
 
-	for _, logger := range loggerSet.loggers {
+	for _, logger := range L().loggers {
 		logger.SetUserPropertiesToLog(userPropertiesToLog)
 	}
 }
@@ -85,29 +103,72 @@ func SetUserPropertiesToLog(userPropertiesToLog *[]UserProperty) {
 //
 // Note: Log sinks added after the most recent call of SetUserPropertiesToLog
 // can have different values.
-func GetUserPropertiesToLog() *[]UserProperty { return loggerSet.userPropertiesToLog }
+func GetUserPropertiesToLog() *[]UserProperty { return L().userPropertiesToLog }
+
+// Convenience function to set the redactor applied to all log entries
+// before they are sent to any log sink; nil disables redaction
+func SetRedactor(redactor *Redactor) {
+	L().SetRedactor(redactor)
+}
+
+// Convenience function to add a processor that is run on every entry before
+// it is sent to the sinks, see LoggerSet.AddProcessor
+func AddProcessor(processor Processor) {
+	L().AddProcessor(processor)
+}
+
+// Convenience function to add a processor that may change the level of
+// entries, see LoggerSet.AddLevelProcessor
+func AddLevelProcessor(processor Processor) {
+	L().AddLevelProcessor(processor)
+}
+
+// Convenience function to set the number of stack frames to skip when finding
+// the caller of each entry, see LoggerSet.SetCallerSkip
+func SetCallerSkip(skip int) {
+	L().SetCallerSkip(skip)
+}
+
+// Convenience function to capture the stack of each entry at or above the
+// given level, see LoggerSet.EnableStackCapture
+func EnableStackCapture(level LogLevel) {
+	L().EnableStackCapture(level)
+}
+
+// Convenience function to stop capturing stacks
+func DisableStackCapture() {
+	L().DisableStackCapture()
+}
 
 func Log(level LogLevel, message string, err error, ctx context.Context) {
-	loggerSet.Log(level, message, err, ctx)
+	L().Log(level, message, err, ctx)
 }
 
 func Logf(level LogLevel, err error, ctx context.Context, format string, args ...interface{}) {
-	loggerSet.Logf(level, err, ctx, format, args...)
+	L().Logf(level, err, ctx, format, args...)
 }
 
 func Logln(level LogLevel, err error, ctx context.Context, args ...interface{}) {
-	loggerSet.Logln(level, err, ctx, args...)
+	L().Logln(level, err, ctx, args...)
 }
 
 func Close(timeout time.Duration) error {
-	return loggerSet.Close(timeout)
+	return L().Close(timeout)
+}
+
+// ReportSinkError writes an error of the named log sink (e.g., failing to send
+// an entry) to stderr.  Sinks cannot log their own errors, as they may be the
+// failing sink, and the Logger interface has no way to return them, so stderr
+// is the fallback of last resort.
+func ReportSinkError(sink string, err error) {
+	fmt.Fprintf(os.Stderr, "%s: %s log sink: %+v\n", Error.String(), sink, err)
 }
 
 // Log the contents of a reader
 func LogReader(level LogLevel, reader io.Reader, prefix string) {
 	buf := new(bytes.Buffer)
 	buf.ReadFrom(reader)
-	loggerSet.Log(level, buf.String(), nil, nil)
+	L().Log(level, buf.String(), nil, nil)
 }
 
 // Add a file logger with the given file name
@@ -115,8 +176,8 @@ func LogReader(level LogLevel, reader io.Reader, prefix string) {
 // Deprecated: use NewFileLogger instead
 func SetupLog(logfile string, minLogLevel LogLevel) Logger {
 	fileLogger := NewFileLogger(logfile, minLogLevel, 500, 3, 28)
-	loggerSet.AddLogger(fileLogger)
-	return loggerSet
+	L().AddLogger(fileLogger)
+	return L()
 }
 
 // Write the given JSON object to the standard log
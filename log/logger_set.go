@@ -2,6 +2,8 @@ package log
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 )
@@ -12,6 +14,13 @@ type LoggerSet struct {
 	loggers             []Logger
 	minimumLevel        LogLevel
 	userPropertiesToLog *[]UserProperty
+	redactor            *Redactor
+	callerSkip          int
+	captureStacks       bool
+	stackLevel          LogLevel
+	processors          []Processor
+	// Whether a processor may change the level of entries, see AddLevelProcessor
+	changesLevels bool
 }
 
 // Create a new log set, with the standard logger
@@ -67,24 +76,124 @@ func (l *LoggerSet) SetUserPropertiesToLog(userPropertiesToLog *[]UserProperty)
 // can have different values.
 func (l *LoggerSet) GetUserPropertiesToLog() *[]UserProperty { return l.userPropertiesToLog }
 
+// SetRedactor sets the redactor applied to all log entries before they are
+// sent to any log sink; nil disables redaction
+func (l *LoggerSet) SetRedactor(redactor *Redactor) {
+	l.redactor = redactor
+}
+
+// GetRedactor returns the most recently set redactor
+func (l *LoggerSet) GetRedactor() *Redactor { return l.redactor }
+
+// AddProcessor adds a processor that is run on every entry before it is
+// redacted and sent to the sinks, after any processors already added.
+//
+// Processors only see entries at a level logged by a sink; use
+// AddLevelProcessor for processors that may raise the level of entries.
+func (l *LoggerSet) AddProcessor(processor Processor) {
+	if processor != nil {
+		l.processors = append(l.processors, processor)
+	}
+}
+
+// AddLevelProcessor adds a processor that may change the level of entries
+// (e.g., LevelProcessor), see AddProcessor
+//
+// Note: with a level processor, entries are created for all levels, as the
+// processor may raise the level of an entry above a sink's minimum level
+func (l *LoggerSet) AddLevelProcessor(processor Processor) {
+	if processor != nil {
+		l.processors = append(l.processors, processor)
+		l.changesLevels = true
+	}
+}
+
+// SetCallerSkip sets the number of stack frames to skip when finding the
+// caller of each entry, after the frames of this package; e.g., 1 if all
+// entries are logged by a helper function wrapping the log functions
+func (l *LoggerSet) SetCallerSkip(skip int) {
+	l.callerSkip = skip
+}
+
+// GetCallerSkip returns the most recently set caller skip
+func (l *LoggerSet) GetCallerSkip() int { return l.callerSkip }
+
+// EnableStackCapture captures the stack from the caller of each entry at or
+// above the given level, which is logged by sinks along with the entry
+func (l *LoggerSet) EnableStackCapture(level LogLevel) {
+	l.captureStacks = true
+	l.stackLevel = level
+}
+
+// DisableStackCapture stops capturing stacks
+func (l *LoggerSet) DisableStackCapture() {
+	l.captureStacks = false
+}
+
 func (l *LoggerSet) Log(level LogLevel, message string, err error, ctx context.Context) {
-	for _, logger := range l.loggers {
-		logger.Log(level, message, err, ctx)
+	if l.isEnabled(level) {
+		l.LogEntry(l.newEntry(level, message, err, ctx))
 	}
 }
 
 func (l *LoggerSet) Logf(level LogLevel, err error, ctx context.Context, format string, args ...interface{}) {
-	for _, logger := range l.loggers {
-		logger.Logf(level, err, ctx, format, args...)
+	if l.isEnabled(level) {
+		l.LogEntry(l.newEntry(level, fmt.Sprintf(format, args...), err, ctx))
 	}
 }
 
 func (l *LoggerSet) Logln(level LogLevel, err error, ctx context.Context, args ...interface{}) {
+	if l.isEnabled(level) {
+		// Remove the trailing newline as all sinks log entries on separate lines
+		l.LogEntry(l.newEntry(level, strings.TrimSuffix(fmt.Sprintln(args...), "\n"), err, ctx))
+	}
+}
+
+// LogEntry runs the processors on the entry and redacts it, then sends the
+// entry to all sinks, with LogEntry for sinks that are an EntryLogger,
+// otherwise with Log
+func (l *LoggerSet) LogEntry(entry *Entry) {
+	for _, processor := range l.processors {
+		if !processor(entry) {
+			return
+		}
+	}
+
+	// Redact last, so fields and messages added by processors are also redacted
+	if l.redactor != nil {
+		entry.Message, entry.Err, entry.Context = l.redactor.Redact(entry.Message, entry.Err, entry.Context)
+	}
+
 	for _, logger := range l.loggers {
-		logger.Logln(level, err, ctx, args...)
+		if entryLogger, ok := logger.(EntryLogger); ok {
+			entryLogger.LogEntry(entry)
+		} else {
+			logger.Log(entry.Level, entry.Message, entry.Err, entry.Context)
+		}
 	}
 }
 
+// newEntry creates an entry, with the caller and optionally the stack
+func (l *LoggerSet) newEntry(level LogLevel, message string, err error, ctx context.Context) *Entry {
+	return newEntry(level, message, err, ctx, l.callerSkip, l.captureStacks && level >= l.stackLevel)
+}
+
+// isEnabled reports whether any sink logs the given level, so entries are only
+// created when needed
+func (l *LoggerSet) isEnabled(level LogLevel) bool {
+	if l.changesLevels {
+		return true
+	}
+
+	for _, logger := range l.loggers {
+		if level >= logger.GetMinimumLevel() {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (l *LoggerSet) Close(timeout time.Duration) error {
 	errors := make(chan error)
 	var wg sync.WaitGroup
@@ -0,0 +1,281 @@
+// Helpers for testing code that logs
+package logtest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Adapptor/service/v2/log"
+)
+
+// A log entry recorded by a CaptureLogger
+type Entry struct {
+	Level          log.LogLevel
+	Message        string
+	Err            error
+	UserProperties map[log.UserProperty]string
+	Fields         log.Fields
+	// Caller that logged the entry, if known
+	Caller *log.Caller
+}
+
+func (e Entry) String() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s: %s", e.Level.String(), e.Message)
+	}
+	return fmt.Sprintf("%s: %s, %v", e.Level.String(), e.Message, e.Err)
+}
+
+// A Logger that records log entries in memory
+type CaptureLogger struct {
+	mu                  sync.Mutex
+	minimumLevel        log.LogLevel
+	userPropertiesToLog *[]log.UserProperty
+	entries             []Entry
+}
+
+// NewCaptureLogger creates a CaptureLogger that records all log levels
+func NewCaptureLogger() *CaptureLogger {
+	return &CaptureLogger{minimumLevel: log.Trace}
+}
+
+func (l *CaptureLogger) SetMinimumLevel(level log.LogLevel) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.minimumLevel = level
+}
+
+func (l *CaptureLogger) GetMinimumLevel() log.LogLevel {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.minimumLevel
+}
+
+func (l *CaptureLogger) SetUserPropertiesToLog(userPropertiesToLog *[]log.UserProperty) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.userPropertiesToLog = userPropertiesToLog
+}
+
+func (l *CaptureLogger) GetUserPropertiesToLog() *[]log.UserProperty {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.userPropertiesToLog
+}
+
+// Records the entry along with all user properties and fields in the context
+func (l *CaptureLogger) Log(level log.LogLevel, message string, err error, ctx context.Context) {
+	l.LogEntry(log.NewEntry(level, message, err, ctx, 1))
+}
+
+// Records the entry along with its caller, and all user properties and fields in its context
+func (l *CaptureLogger) LogEntry(logEntry *log.Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if logEntry.Level < l.minimumLevel {
+		return
+	}
+
+	ctx := logEntry.Context
+	entry := Entry{Level: logEntry.Level, Message: logEntry.Message, Err: logEntry.Err, Caller: logEntry.Caller}
+
+	if userPropertiesMap := log.GetUserPropertiesMap(ctx); userPropertiesMap != nil {
+		entry.UserProperties = make(map[log.UserProperty]string, len(*userPropertiesMap))
+		for userProperty, value := range *userPropertiesMap {
+			entry.UserProperties[userProperty] = value
+		}
+	}
+
+	if fields := log.GetFields(ctx); fields != nil {
+		entry.Fields = make(log.Fields, len(fields))
+		for key, value := range fields {
+			entry.Fields[key] = value
+		}
+	}
+
+	l.entries = append(l.entries, entry)
+}
+
+func (l *CaptureLogger) Logf(level log.LogLevel, err error, ctx context.Context, format string, args ...interface{}) {
+	l.LogEntry(log.NewEntry(level, fmt.Sprintf(format, args...), err, ctx, 1))
+}
+
+func (l *CaptureLogger) Logln(level log.LogLevel, err error, ctx context.Context, args ...interface{}) {
+	l.LogEntry(log.NewEntry(level, strings.TrimSuffix(fmt.Sprintln(args...), "\n"), err, ctx, 1))
+}
+
+func (l *CaptureLogger) Close(timeout time.Duration) error {
+	return nil
+}
+
+// Entries returns a copy of all recorded entries
+func (l *CaptureLogger) Entries() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Entry(nil), l.entries...)
+}
+
+// Reset discards all recorded entries
+func (l *CaptureLogger) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = nil
+}
+
+// Find returns the recorded entries of the given level whose message or error contains the given substring
+func (l *CaptureLogger) Find(level log.LogLevel, substring string) []Entry {
+	var found []Entry
+
+	for _, entry := range l.Entries() {
+		if entry.Level == level && entry.contains(substring) {
+			found = append(found, entry)
+		}
+	}
+
+	return found
+}
+
+// RequireLogged fails the test immediately if no entry of the given level contains the given substring
+func (l *CaptureLogger) RequireLogged(t testing.TB, level log.LogLevel, substring string) Entry {
+	t.Helper()
+
+	found := l.Find(level, substring)
+	if len(found) == 0 {
+		t.Fatalf("expected a %s log containing %q, logged:\n%s", level.String(), substring, l.describe())
+	}
+
+	return found[0]
+}
+
+// RequireNotLogged fails the test immediately if any entry of the given level contains the given substring
+func (l *CaptureLogger) RequireNotLogged(t testing.TB, level log.LogLevel, substring string) {
+	t.Helper()
+
+	if found := l.Find(level, substring); len(found) > 0 {
+		t.Fatalf("unexpected %s log containing %q: %s", level.String(), substring, found[0].String())
+	}
+}
+
+func (e Entry) contains(substring string) bool {
+	return strings.Contains(e.Message, substring) || (e.Err != nil && strings.Contains(e.Err.Error(), substring))
+}
+
+func (l *CaptureLogger) describe() string {
+	entries := l.Entries()
+	if len(entries) == 0 {
+		return "  (nothing)"
+	}
+
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = "  " + entry.String()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// An active capture of the global logger
+type captureFrame struct {
+	name     string
+	previous *log.LoggerSet
+	// Serialises captures by subtests of the capturing test
+	children sync.Mutex
+}
+
+var (
+	// Serialises captures by tests that are not subtests of a capturing test
+	globalMu sync.Mutex
+
+	capturesMu sync.Mutex
+	// Active captures, outermost first
+	frames   []*captureFrame
+	captures = map[testing.TB]*CaptureLogger{}
+)
+
+// Capture replaces the global LoggerSet (log.L and the global log functions,
+// log.Log, log.Logf, etc.) with one containing only a new CaptureLogger for
+// the duration of the test, and restores it on cleanup.  Calling Capture
+// again in the same test returns the same CaptureLogger.
+//
+// Capture serialises tests: if another test is capturing the global logger,
+// Capture waits until that test finishes, so tests that call Capture do not
+// see each other's entries even if they run in parallel.  A subtest of a
+// capturing test may also call Capture, which captures the subtest's entries
+// until it finishes; parallel subtests capture one at a time.
+func Capture(t testing.TB) *CaptureLogger {
+	t.Helper()
+
+	lock := &globalMu
+
+	capturesMu.Lock()
+	if capture, ok := captures[t]; ok {
+		capturesMu.Unlock()
+		return capture
+	}
+	for i := len(frames) - 1; i >= 0; i-- {
+		if strings.HasPrefix(t.Name(), frames[i].name+"/") {
+			lock = &frames[i].children
+			break
+		}
+	}
+	capturesMu.Unlock()
+
+	lock.Lock()
+
+	capture := NewCaptureLogger()
+	set := &log.LoggerSet{}
+	set.AddLogger(capture)
+
+	capturesMu.Lock()
+	frame := &captureFrame{name: t.Name(), previous: log.ReplaceLoggerSet(set)}
+	frames = append(frames, frame)
+	captures[t] = capture
+	capturesMu.Unlock()
+
+	t.Cleanup(func() {
+		capturesMu.Lock()
+		delete(captures, t)
+		frames = frames[:len(frames)-1]
+		log.ReplaceLoggerSet(frame.previous)
+		capturesMu.Unlock()
+
+		lock.Unlock()
+	})
+
+	return capture
+}
+
+// RequireLogged fails the test immediately if the global logger captured for
+// the test has no entry of the given level containing the given substring.
+// Capture must have been called for the test.
+func RequireLogged(t testing.TB, level log.LogLevel, substring string) Entry {
+	t.Helper()
+	return captured(t).RequireLogged(t, level, substring)
+}
+
+// RequireNotLogged fails the test immediately if the global logger captured
+// for the test has any entry of the given level containing the given
+// substring.  Capture must have been called for the test.
+func RequireNotLogged(t testing.TB, level log.LogLevel, substring string) {
+	t.Helper()
+	captured(t).RequireNotLogged(t, level, substring)
+}
+
+func captured(t testing.TB) *CaptureLogger {
+	t.Helper()
+
+	capturesMu.Lock()
+	capture, ok := captures[t]
+	capturesMu.Unlock()
+
+	if !ok {
+		t.Fatal("logtest.Capture must be called before checking the global logger")
+	}
+
+	return capture
+}
@@ -0,0 +1,106 @@
+package logtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Adapptor/service/v2/log"
+)
+
+// Test Capture records entries logged through the global logger, including context properties
+func TestCapture(t *testing.T) {
+	capture := Capture(t)
+
+	userProperties := map[log.UserProperty]string{log.UserPropertyId: "42"}
+	ctx := context.WithValue(context.Background(), log.UserPropertiesKey, &userProperties)
+	ctx = log.WithField(ctx, "orderId", "1234")
+
+	log.Logf(log.Error, errors.New("connection refused"), ctx, "failed to save order %d", 1234)
+	log.Log(log.Debug, "saving order", nil, nil)
+
+	entry := RequireLogged(t, log.Error, "failed to save order 1234")
+	if entry.UserProperties[log.UserPropertyId] != "42" || entry.Fields["orderId"] != "1234" {
+		t.Errorf("context properties not captured: %+v", entry)
+	}
+	RequireLogged(t, log.Error, "connection refused")
+	RequireNotLogged(t, log.Error, "saving order")
+
+	if len(capture.Entries()) != 2 {
+		t.Errorf("expected 2 entries, got %d", len(capture.Entries()))
+	}
+}
+
+// Test Capture also captures entries logged through log.L, and calling it
+// again in the same test returns the same capture
+func TestCaptureL(t *testing.T) {
+	capture := Capture(t)
+
+	log.L().Log(log.Warning, "logged through L", nil, nil)
+
+	RequireLogged(t, log.Warning, "logged through L")
+	if again := Capture(t); again != capture {
+		t.Error("expected the same capture for a repeated call")
+	}
+}
+
+// Test each test sees only its own entries
+func TestCaptureIsolated(t *testing.T) {
+	capture := Capture(t)
+
+	log.Log(log.Info, "isolated test", nil, nil)
+
+	RequireNotLogged(t, log.Error, "failed to save order")
+	if len(capture.Entries()) != 1 {
+		t.Errorf("expected 1 entry, got %d", len(capture.Entries()))
+	}
+}
+
+// Test a subtest of a capturing test captures its own entries, and the outer
+// capture resumes afterwards
+func TestCaptureNested(t *testing.T) {
+	outer := Capture(t)
+
+	t.Run("subtest", func(t *testing.T) {
+		inner := Capture(t)
+
+		log.Log(log.Info, "in subtest", nil, nil)
+
+		RequireLogged(t, log.Info, "in subtest")
+		if len(inner.Entries()) != 1 {
+			t.Errorf("expected 1 entry, got %d", len(inner.Entries()))
+		}
+	})
+
+	log.Log(log.Info, "after subtest", nil, nil)
+
+	RequireLogged(t, log.Info, "after subtest")
+	RequireNotLogged(t, log.Info, "in subtest")
+	if len(outer.Entries()) != 1 {
+		t.Errorf("expected 1 entry, got %d", len(outer.Entries()))
+	}
+}
+
+// Test parallel tests calling Capture are serialised and see only their own entries
+func TestCaptureParallel(t *testing.T) {
+	for i := 0; i < 4; i++ {
+		message := fmt.Sprintf("parallel test %d", i)
+
+		t.Run(message, func(t *testing.T) {
+			t.Parallel()
+
+			capture := Capture(t)
+
+			for j := 0; j < 10; j++ {
+				log.Log(log.Info, message, nil, nil)
+				time.Sleep(time.Millisecond)
+			}
+
+			if entries := capture.Entries(); len(entries) != 10 || len(capture.Find(log.Info, message)) != 10 {
+				t.Errorf("expected only the test's 10 entries, got %v", entries)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+
+	serviceerrors "github.com/Adapptor/service/v2/errors"
+)
+
+// Protocol for exporting OTLP log records
+type OtlpProtocol string
+
+const (
+	OtlpGrpc OtlpProtocol = "grpc"
+	OtlpHttp OtlpProtocol = "http"
+)
+
+// Name of the OpenTelemetry instrumentation scope of log records
+const otlpScopeName = "github.com/Adapptor/service/v2/log"
+
+// Resource attributes identifying the service that emits log records
+type OtlpResource struct {
+	// service.name, e.g., "acme api"
+	ServiceName string
+	// service.version, e.g., "v1.2.3"
+	ServiceVersion string
+	// deployment.environment.name, e.g., "Production"
+	Environment string
+}
+
+// Map of log levels to OpenTelemetry severities
+var logLevelToOtlpSeverity = map[LogLevel]otellog.Severity{
+	Trace:   otellog.SeverityTrace,
+	Debug:   otellog.SeverityDebug,
+	Info:    otellog.SeverityInfo,
+	Warning: otellog.SeverityWarn,
+	Error:   otellog.SeverityError,
+	Fatal:   otellog.SeverityFatal,
+}
+
+// Map of user properties to OpenTelemetry attributes
+var userPropertyToOtlpAttribute = map[UserProperty]string{
+	UserPropertyId:    string(semconv.UserIDKey),
+	UserPropertyEmail: string(semconv.UserEmailKey),
+	UserPropertyName:  string(semconv.UserNameKey),
+}
+
+// A logger that exports OpenTelemetry log records to an OTLP endpoint (e.g.,
+// an OpenTelemetry collector)
+//
+// Log records are correlated with the OpenTelemetry trace and span in the
+// context, if any.  Fields and user properties to log are exported as
+// attributes, and errors as exception attributes.
+type OtlpLogger struct {
+	provider            *sdklog.LoggerProvider
+	logger              otellog.Logger
+	minimumLevel        LogLevel
+	userPropertiesToLog *[]UserProperty
+}
+
+// NewOtlpLogger creates a logger that exports log records in batches
+//
+// protocol: OtlpGrpc or OtlpHttp
+// endpoint: the host and port of the OTLP endpoint, e.g., "localhost:4317" for gRPC or "localhost:4318" for HTTP
+// insecure: whether to connect without TLS
+// serviceResource: resource attributes identifying the service
+// minimumLevel: minimum log level
+func NewOtlpLogger(protocol OtlpProtocol, endpoint string, insecure bool, serviceResource OtlpResource, minimumLevel LogLevel) (*OtlpLogger, error) {
+	ctx := context.Background()
+
+	var exporter sdklog.Exporter
+	var err error
+
+	switch protocol {
+	case OtlpGrpc:
+		options := []otlploggrpc.Option{otlploggrpc.WithEndpoint(endpoint)}
+		if insecure {
+			options = append(options, otlploggrpc.WithInsecure())
+		}
+		exporter, err = otlploggrpc.New(ctx, options...)
+	case OtlpHttp:
+		options := []otlploghttp.Option{otlploghttp.WithEndpoint(endpoint)}
+		if insecure {
+			options = append(options, otlploghttp.WithInsecure())
+		}
+		exporter, err = otlploghttp.New(ctx, options...)
+	default:
+		err = fmt.Errorf("unsupported OTLP protocol %s", protocol)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdklog.NewLoggerProvider(
+		sdklog.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(serviceResource.ServiceName),
+			semconv.ServiceVersion(serviceResource.ServiceVersion),
+			semconv.DeploymentEnvironmentName(serviceResource.Environment),
+		)),
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(exporter)),
+	)
+
+	return &OtlpLogger{
+		provider:     provider,
+		logger:       provider.Logger(otlpScopeName),
+		minimumLevel: minimumLevel,
+	}, nil
+}
+
+func (l *OtlpLogger) SetMinimumLevel(level LogLevel) {
+	l.minimumLevel = level
+}
+
+func (l *OtlpLogger) GetMinimumLevel() LogLevel {
+	return l.minimumLevel
+}
+
+func (l *OtlpLogger) SetUserPropertiesToLog(userPropertiesToLog *[]UserProperty) {
+	l.userPropertiesToLog = userPropertiesToLog
+}
+
+func (l *OtlpLogger) GetUserPropertiesToLog() *[]UserProperty { return l.userPropertiesToLog }
+
+func (l *OtlpLogger) Log(level LogLevel, message string, err error, ctx context.Context) {
+	if level >= l.minimumLevel {
+		l.LogEntry(newEntry(level, message, err, ctx, 0, false))
+	}
+}
+
+// Exports the entry, with its caller and stack as code attributes
+func (l *OtlpLogger) LogEntry(entry *Entry) {
+	if entry.Level >= l.minimumLevel {
+		ctx, err := entry.Context, entry.Err
+
+		var record otellog.Record
+		record.SetTimestamp(entry.Time)
+		record.SetSeverity(logLevelToOtlpSeverity[entry.Level])
+		record.SetSeverityText(entry.Level.String())
+		record.SetBody(otellog.StringValue(entry.Message))
+
+		for key, value := range GetFields(ctx) {
+			record.AddAttributes(otellog.String(key, value))
+		}
+
+		for userProperty, value := range GetUserPropertiesToLogMap(ctx, l.userPropertiesToLog) {
+			record.AddAttributes(otellog.String(otlpUserAttribute(userProperty), value))
+		}
+
+		if err != nil {
+			record.AddAttributes(
+				otellog.String(string(semconv.ExceptionTypeKey), reflect.TypeOf(err).String()),
+				otellog.String(string(semconv.ExceptionMessageKey), err.Error()),
+			)
+			if stack := serviceerrors.StackOf(err); len(stack) > 0 {
+				record.AddAttributes(otellog.String(string(semconv.ExceptionStacktraceKey), strings.TrimPrefix(serviceerrors.FormatStack(stack), "\n")))
+			}
+		}
+
+		if entry.Caller != nil {
+			record.AddAttributes(
+				otellog.String(string(semconv.CodeFunctionNameKey), entry.Caller.Function),
+				otellog.String(string(semconv.CodeFilePathKey), entry.Caller.File),
+				otellog.Int(string(semconv.CodeLineNumberKey), entry.Caller.Line),
+			)
+		}
+
+		if len(entry.Stack) > 0 {
+			record.AddAttributes(otellog.String(string(semconv.CodeStacktraceKey), strings.TrimPrefix(serviceerrors.FormatStack(entry.Stack), "\n")))
+		}
+
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		// The trace and span are read from the context
+		l.logger.Emit(ctx, record)
+	}
+}
+
+func (l *OtlpLogger) Logf(level LogLevel, err error, ctx context.Context, format string, args ...interface{}) {
+	if level >= l.minimumLevel {
+		l.Log(level, fmt.Sprintf(format, args...), err, ctx)
+	}
+}
+
+func (l *OtlpLogger) Logln(level LogLevel, err error, ctx context.Context, args ...interface{}) {
+	if level >= l.minimumLevel {
+		l.Log(level, strings.TrimSuffix(fmt.Sprintln(args...), "\n"), err, ctx)
+	}
+}
+
+// Exports all buffered log records and shuts down the exporter
+func (l *OtlpLogger) Close(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return l.provider.Shutdown(ctx)
+}
+
+// otlpUserAttribute returns the attribute name for a user property
+func otlpUserAttribute(userProperty UserProperty) string {
+	if attribute, ok := userPropertyToOtlpAttribute[userProperty]; ok {
+		return attribute
+	}
+	return "user." + string(userProperty)
+}
@@ -0,0 +1,135 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	collectorlogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// An in-process OTLP collector stub that records export requests, over HTTP
+// or as a gRPC logs service
+type otlpCollectorStub struct {
+	collectorlogs.UnimplementedLogsServiceServer
+
+	mu       sync.Mutex
+	requests []*collectorlogs.ExportLogsServiceRequest
+}
+
+func (c *otlpCollectorStub) Export(ctx context.Context, request *collectorlogs.ExportLogsServiceRequest) (*collectorlogs.ExportLogsServiceResponse, error) {
+	c.mu.Lock()
+	c.requests = append(c.requests, request)
+	c.mu.Unlock()
+
+	return &collectorlogs.ExportLogsServiceResponse{}, nil
+}
+
+func (c *otlpCollectorStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	request := &collectorlogs.ExportLogsServiceRequest{}
+	if err := proto.Unmarshal(body, request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	exportResponse, _ := c.Export(r.Context(), request)
+
+	response, _ := proto.Marshal(exportResponse)
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(response)
+}
+
+func attributesMap(attributes []*commonpb.KeyValue) map[string]string {
+	result := map[string]string{}
+	for _, attribute := range attributes {
+		result[attribute.Key] = attribute.Value.GetStringValue()
+	}
+	return result
+}
+
+func TestOtlpLoggerHttp(t *testing.T) {
+	collector := &otlpCollectorStub{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	testOtlpLoggerExport(t, OtlpHttp, strings.TrimPrefix(server.URL, "http://"), collector)
+}
+
+func TestOtlpLoggerGrpc(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collector := &otlpCollectorStub{}
+	server := grpc.NewServer()
+	collectorlogs.RegisterLogsServiceServer(server, collector)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	testOtlpLoggerExport(t, OtlpGrpc, listener.Addr().String(), collector)
+}
+
+// testOtlpLoggerExport logs with an OtlpLogger exporting to the collector stub
+// and checks the exported log record
+func testOtlpLoggerExport(t *testing.T, protocol OtlpProtocol, endpoint string, collector *otlpCollectorStub) {
+	t.Helper()
+
+	logger, err := NewOtlpLogger(protocol, endpoint, true,
+		OtlpResource{ServiceName: "test-service", ServiceVersion: "v1.2.3-dev", Environment: "Development"}, Info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.SetUserPropertiesToLog(&[]UserProperty{UserPropertyId})
+
+	traceId := trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	spanId := trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8}
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceId, SpanID: spanId}))
+	userProperties := map[UserProperty]string{UserPropertyId: "42", UserPropertyEmail: "jane.doe@example.com"}
+	ctx = context.WithValue(ctx, UserPropertiesKey, &userProperties)
+	ctx = WithField(ctx, "orderId", "1234")
+
+	logger.Log(Debug, "below the minimum level", nil, ctx)
+	logger.Log(Error, "payment failed", errors.New("declined"), ctx)
+
+	if err := logger.Close(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(collector.requests) != 1 {
+		t.Fatalf("expected 1 export request, got %d", len(collector.requests))
+	}
+	resourceLogs := collector.requests[0].ResourceLogs[0]
+	resourceAttributes := attributesMap(resourceLogs.Resource.Attributes)
+	if resourceAttributes["service.name"] != "test-service" || resourceAttributes["service.version"] != "v1.2.3-dev" || resourceAttributes["deployment.environment.name"] != "Development" {
+		t.Errorf("unexpected resource attributes: %+v", resourceAttributes)
+	}
+
+	records := resourceLogs.ScopeLogs[0].LogRecords
+	if len(records) != 1 {
+		t.Fatalf("expected 1 log record, got %d", len(records))
+	}
+	record := records[0]
+	if record.Body.GetStringValue() != "payment failed" || record.SeverityText != "ERROR" || record.SeverityNumber != 17 {
+		t.Errorf("unexpected log record: %+v", record)
+	}
+	if trace.TraceID(record.TraceId) != traceId || trace.SpanID(record.SpanId) != spanId {
+		t.Errorf("log record not correlated with the span: %x %x", record.TraceId, record.SpanId)
+	}
+	attributes := attributesMap(record.Attributes)
+	if attributes["orderId"] != "1234" || attributes["user.id"] != "42" || attributes["user.email"] != "" || attributes["exception.message"] != "declined" {
+		t.Errorf("unexpected log record attributes: %+v", attributes)
+	}
+}
@@ -0,0 +1,85 @@
+package log
+
+import (
+	"context"
+	"os"
+	"regexp"
+)
+
+// A processor of log entries, run by LoggerSet before entries are sent to
+// sinks.  A processor may modify the entry (e.g., add fields or change its
+// level), and returns false to drop the entry.
+type Processor func(entry *Entry) bool
+
+// AddFields adds fields to the entry's context; fields already in the context
+// are not replaced
+func (e *Entry) AddFields(fields Fields) {
+	merged := Fields{}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	for key, value := range GetFields(e.Context) {
+		merged[key] = value
+	}
+
+	ctx := e.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	e.Context = context.WithValue(ctx, FieldsKey, merged)
+}
+
+// FieldsProcessor returns a processor that adds the given fields to every entry
+func FieldsProcessor(fields Fields) Processor {
+	return func(entry *Entry) bool {
+		entry.AddFields(fields)
+		return true
+	}
+}
+
+// HostFields returns fields identifying the host: "hostname", and "pod" and
+// "namespace" if the POD_NAME and POD_NAMESPACE environment variables are set
+// (e.g., with the Kubernetes downward API)
+func HostFields() Fields {
+	fields := Fields{}
+
+	if hostname, err := os.Hostname(); err == nil {
+		fields["hostname"] = hostname
+	}
+	if pod := os.Getenv("POD_NAME"); pod != "" {
+		fields["pod"] = pod
+	}
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		fields["namespace"] = namespace
+	}
+
+	return fields
+}
+
+// DropProcessor returns a processor that drops entries matching the given function
+func DropProcessor(match func(entry *Entry) bool) Processor {
+	return func(entry *Entry) bool {
+		return !match(entry)
+	}
+}
+
+// DropMessagesProcessor returns a processor that drops entries at or below
+// the given level whose message matches the pattern, e.g., to drop successful
+// health check requests while still logging their errors
+func DropMessagesProcessor(pattern *regexp.Regexp, maximumLevel LogLevel) Processor {
+	return DropProcessor(func(entry *Entry) bool {
+		return entry.Level <= maximumLevel && pattern.MatchString(entry.Message)
+	})
+}
+
+// LevelProcessor returns a processor that changes the level of entries whose
+// message matches the pattern, e.g., to downgrade a noisy warning to debug.
+// Add processors raising levels with AddLevelProcessor.
+func LevelProcessor(pattern *regexp.Regexp, level LogLevel) Processor {
+	return func(entry *Entry) bool {
+		if pattern.MatchString(entry.Message) {
+			entry.Level = level
+		}
+		return true
+	}
+}
@@ -0,0 +1,112 @@
+package log
+
+import (
+	"context"
+	"regexp"
+	"testing"
+)
+
+// An entryRecordingLogger with a minimum level
+type minimumLevelLogger struct {
+	entryRecordingLogger
+	minimumLevel LogLevel
+}
+
+func (l *minimumLevelLogger) GetMinimumLevel() LogLevel { return l.minimumLevel }
+
+func (l *minimumLevelLogger) LogEntry(entry *Entry) {
+	if entry.Level >= l.minimumLevel {
+		l.entryRecordingLogger.LogEntry(entry)
+	}
+}
+
+func TestLoggerSetProcessors(t *testing.T) {
+	recorder := &minimumLevelLogger{minimumLevel: Warning}
+	set := &LoggerSet{}
+	set.AddLogger(recorder)
+
+	set.AddProcessor(FieldsProcessor(Fields{"version": "v1.2.3", "orderId": "static"}))
+	set.AddProcessor(DropMessagesProcessor(regexp.MustCompile(`^GET /health`), Warning))
+	set.AddLevelProcessor(LevelProcessor(regexp.MustCompile(`payment declined`), Error))
+
+	ctx := WithField(context.Background(), "orderId", "1234")
+	set.Log(Warning, "GET /health 200", nil, ctx)
+	set.Log(Error, "GET /health 500", nil, ctx)
+	set.Log(Info, "payment declined", nil, ctx)
+	set.Log(Info, "payment accepted", nil, ctx)
+
+	if len(recorder.logEntries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(recorder.logEntries))
+	}
+
+	if entry := recorder.logEntries[0]; entry.Message != "GET /health 500" {
+		t.Errorf("expected health check errors to be logged, got %q", entry.Message)
+	}
+
+	entry := recorder.logEntries[1]
+	if entry.Message != "payment declined" || entry.Level != Error {
+		t.Errorf("expected the level of the declined payment to be raised, got %s %q", entry.Level, entry.Message)
+	}
+
+	fields := GetFields(entry.Context)
+	if fields["version"] != "v1.2.3" || fields["orderId"] != "1234" {
+		t.Errorf("expected processor fields to be added without replacing context fields, got %v", fields)
+	}
+
+	// The original context is not modified
+	if GetFields(ctx)["version"] != "" {
+		t.Error("expected the original context to be unchanged")
+	}
+}
+
+// Test entries are redacted after the processors run, so fields they add are redacted
+func TestLoggerSetRedactsProcessedEntries(t *testing.T) {
+	recorder := &minimumLevelLogger{minimumLevel: Info}
+	set := &LoggerSet{}
+	set.AddLogger(recorder)
+
+	redactor := NewRedactor()
+	redactor.AllowedFields = []string{"version"}
+	set.SetRedactor(redactor)
+
+	set.AddProcessor(FieldsProcessor(Fields{"version": "v1.2.3", "hostname": "db-1"}))
+	set.AddProcessor(func(entry *Entry) bool {
+		entry.Message += " for jane.doe@example.com"
+		return true
+	})
+
+	set.Log(Info, "order placed", nil, nil)
+
+	if len(recorder.logEntries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(recorder.logEntries))
+	}
+	entry := recorder.logEntries[0]
+	if entry.Message != "order placed for [REDACTED EMAIL]" {
+		t.Errorf("expected the processed message to be redacted, got %q", entry.Message)
+	}
+	if fields := GetFields(entry.Context); fields["version"] != "v1.2.3" || fields["hostname"] != RedactedValue {
+		t.Errorf("expected processor fields to be redacted, got %v", fields)
+	}
+}
+
+// Test processors that do not change levels do not enable entries below the sinks' minimum level
+func TestLoggerSetProcessorsRespectMinimumLevel(t *testing.T) {
+	set := &LoggerSet{}
+	set.AddLogger(&minimumLevelLogger{minimumLevel: Warning})
+
+	processed := 0
+	set.AddProcessor(func(entry *Entry) bool {
+		processed++
+		return true
+	})
+
+	set.Log(Debug, "disabled", nil, nil)
+	if processed != 0 || set.isEnabled(Debug) {
+		t.Error("expected disabled entries not to be created")
+	}
+
+	set.AddLevelProcessor(LevelProcessor(regexp.MustCompile(`important`), Error))
+	if !set.isEnabled(Debug) {
+		t.Error("expected all levels to be enabled with a level processor")
+	}
+}
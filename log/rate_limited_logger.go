@@ -0,0 +1,263 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Default window during which repeats of an identical message are suppressed
+const DefaultDeduplicationWindow = time.Minute
+
+// A token bucket limit for a log level
+type RateLimit struct {
+	// Sustained number of log entries per second
+	PerSecond float64
+	// Maximum number of log entries allowed in a single burst
+	Burst int
+}
+
+// Options for a RateLimitedLogger
+type RateLimitOptions struct {
+	// Repeats of an identical level, message and error within this window are
+	// suppressed, and summarised once the window expires.  Defaults to
+	// DefaultDeduplicationWindow.
+	DeduplicationWindow time.Duration
+	// Token bucket limits by log level; levels without a limit are only deduplicated
+	LevelLimits map[LogLevel]RateLimit
+}
+
+type rateLimitKey struct {
+	level   LogLevel
+	message string
+	err     string
+}
+
+type rateLimitEntry struct {
+	firstLogged time.Time
+	err         error
+	suppressed  int
+}
+
+type rateLimitSummary struct {
+	level   LogLevel
+	message string
+	err     error
+}
+
+// summary describes the entries suppressed as repeats of the entry
+func (e *rateLimitEntry) summary(key rateLimitKey) rateLimitSummary {
+	return rateLimitSummary{
+		level:   key.level,
+		message: fmt.Sprintf("suppressed %d similar messages: %s", e.suppressed, key.message),
+		err:     e.err,
+	}
+}
+
+// A Logger that wraps another log sink, suppressing repeated messages and
+// limiting the rate of log entries per level.
+//
+// Suppressed entries are summarised periodically with a "suppressed N similar
+// messages" log entry at the same level.
+type RateLimitedLogger struct {
+	logger   Logger
+	window   time.Duration
+	limiters map[LogLevel]*rate.Limiter
+
+	mu          sync.Mutex
+	entries     map[rateLimitKey]*rateLimitEntry
+	rateLimited map[LogLevel]int
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewRateLimitedLogger wraps the given logger with deduplication and rate limiting
+//
+// logger: the log sink to wrap (e.g., a SentryLogger)
+// options: deduplication window and per level rate limits
+func NewRateLimitedLogger(logger Logger, options RateLimitOptions) *RateLimitedLogger {
+	window := options.DeduplicationWindow
+	if window <= 0 {
+		window = DefaultDeduplicationWindow
+	}
+
+	limiters := make(map[LogLevel]*rate.Limiter)
+	for level, limit := range options.LevelLimits {
+		limiters[level] = rate.NewLimiter(rate.Limit(limit.PerSecond), limit.Burst)
+	}
+
+	l := &RateLimitedLogger{
+		logger:      logger,
+		window:      window,
+		limiters:    limiters,
+		entries:     make(map[rateLimitKey]*rateLimitEntry),
+		rateLimited: make(map[LogLevel]int),
+		done:        make(chan struct{}),
+	}
+
+	go l.summarise()
+
+	return l
+}
+
+func (l *RateLimitedLogger) SetMinimumLevel(level LogLevel) {
+	l.logger.SetMinimumLevel(level)
+}
+
+func (l *RateLimitedLogger) GetMinimumLevel() LogLevel {
+	return l.logger.GetMinimumLevel()
+}
+
+func (l *RateLimitedLogger) SetUserPropertiesToLog(userPropertiesToLog *[]UserProperty) {
+	l.logger.SetUserPropertiesToLog(userPropertiesToLog)
+}
+
+func (l *RateLimitedLogger) GetUserPropertiesToLog() *[]UserProperty {
+	return l.logger.GetUserPropertiesToLog()
+}
+
+func (l *RateLimitedLogger) Log(level LogLevel, message string, err error, ctx context.Context) {
+	if level >= l.logger.GetMinimumLevel() && l.allow(level, message, err) {
+		l.logger.Log(level, message, err, ctx)
+	}
+}
+
+// Passes the entry to the wrapped logger with LogEntry if it is an EntryLogger, otherwise with Log
+func (l *RateLimitedLogger) LogEntry(entry *Entry) {
+	if entry.Level >= l.logger.GetMinimumLevel() && l.allow(entry.Level, entry.Message, entry.Err) {
+		if entryLogger, ok := l.logger.(EntryLogger); ok {
+			entryLogger.LogEntry(entry)
+		} else {
+			l.logger.Log(entry.Level, entry.Message, entry.Err, entry.Context)
+		}
+	}
+}
+
+func (l *RateLimitedLogger) Logf(level LogLevel, err error, ctx context.Context, format string, args ...interface{}) {
+	if level >= l.logger.GetMinimumLevel() {
+		l.Log(level, fmt.Sprintf(format, args...), err, ctx)
+	}
+}
+
+func (l *RateLimitedLogger) Logln(level LogLevel, err error, ctx context.Context, args ...interface{}) {
+	if level >= l.logger.GetMinimumLevel() {
+		message := fmt.Sprintln(args...)
+
+		// Remove the trailing newline from message as Log writes a newline
+		if len(message) > 0 && message[len(message)-1] == '\n' {
+			message = message[:len(message)-1]
+		}
+
+		l.Log(level, message, err, ctx)
+	}
+}
+
+// Stops the summary timer, logs any outstanding summaries and closes the wrapped logger
+func (l *RateLimitedLogger) Close(timeout time.Duration) error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.flush(time.Time{})
+	})
+
+	return l.logger.Close(timeout)
+}
+
+// allow reports whether a log entry should be passed to the wrapped logger,
+// recording it as suppressed otherwise.  A summary is logged for a repeated
+// entry whose window has expired before the entry replaces it.
+func (l *RateLimitedLogger) allow(level LogLevel, message string, err error) bool {
+	key := rateLimitKey{level: level, message: message}
+	if err != nil {
+		key.err = err.Error()
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+
+	entry, ok := l.entries[key]
+	if ok && now.Sub(entry.firstLogged) < l.window {
+		entry.suppressed++
+		l.mu.Unlock()
+		return false
+	}
+
+	var expired []rateLimitSummary
+	if ok {
+		delete(l.entries, key)
+		if entry.suppressed > 0 {
+			expired = append(expired, entry.summary(key))
+		}
+	}
+
+	allowed := true
+	if limiter, ok := l.limiters[level]; ok && !limiter.AllowN(now, 1) {
+		l.rateLimited[level]++
+		allowed = false
+	} else {
+		l.entries[key] = &rateLimitEntry{firstLogged: now, err: err}
+	}
+
+	l.mu.Unlock()
+
+	l.logSummaries(expired)
+
+	return allowed
+}
+
+// summarise periodically logs summaries of suppressed entries until the logger is closed
+func (l *RateLimitedLogger) summarise() {
+	ticker := time.NewTicker(l.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case now := <-ticker.C:
+			l.flush(now)
+		}
+	}
+}
+
+// flush logs summaries for deduplicated entries whose window expired before
+// the given time, or for all entries if the time is zero
+func (l *RateLimitedLogger) flush(now time.Time) {
+	var summaries []rateLimitSummary
+
+	l.mu.Lock()
+	for key, entry := range l.entries {
+		if !now.IsZero() && now.Sub(entry.firstLogged) < l.window {
+			continue
+		}
+
+		delete(l.entries, key)
+
+		if entry.suppressed > 0 {
+			summaries = append(summaries, entry.summary(key))
+		}
+	}
+	for level, count := range l.rateLimited {
+		delete(l.rateLimited, level)
+
+		summaries = append(summaries, rateLimitSummary{
+			level:   level,
+			message: fmt.Sprintf("suppressed %d %s messages exceeding the rate limit", count, level.String()),
+		})
+	}
+	l.mu.Unlock()
+
+	l.logSummaries(summaries)
+}
+
+// logSummaries logs summaries to the wrapped logger.  Call it outside the
+// lock; summaries are not subject to deduplication or rate limits.
+func (l *RateLimitedLogger) logSummaries(summaries []rateLimitSummary) {
+	for _, s := range summaries {
+		l.logger.Log(s.level, s.message, s.err, nil)
+	}
+}
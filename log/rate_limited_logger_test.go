@@ -0,0 +1,143 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedEntry struct {
+	level   LogLevel
+	message string
+	err     error
+}
+
+type RecordingLogger struct {
+	mu      sync.Mutex
+	entries []recordedEntry
+}
+
+func (l *RecordingLogger) SetMinimumLevel(logLevel LogLevel) {}
+func (l *RecordingLogger) GetMinimumLevel() LogLevel {
+	return Trace
+}
+func (l *RecordingLogger) SetUserPropertiesToLog(userPropertiesToLog *[]UserProperty) {}
+func (l *RecordingLogger) GetUserPropertiesToLog() *[]UserProperty                    { return nil }
+func (l *RecordingLogger) Log(level LogLevel, message string, err error, ctx context.Context) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, recordedEntry{level: level, message: message, err: err})
+}
+func (l *RecordingLogger) Logf(level LogLevel, err error, ctx context.Context, format string, args ...interface{}) {
+}
+func (l *RecordingLogger) Logln(level LogLevel, err error, ctx context.Context, args ...interface{}) {
+}
+func (l *RecordingLogger) Close(timeout time.Duration) error { return nil }
+
+// Test RateLimitedLogger suppresses repeats within the window and summarises them on Close
+func TestRateLimitedLoggerDeduplicates(t *testing.T) {
+	recorder := &RecordingLogger{}
+	logger := NewRateLimitedLogger(recorder, RateLimitOptions{DeduplicationWindow: time.Hour})
+
+	err := errors.New("connection refused")
+	for i := 0; i < 100; i++ {
+		logger.Log(Error, "redis unavailable", err, nil)
+	}
+	logger.Log(Error, "a different message", err, nil)
+	logger.Close(time.Second)
+
+	if len(recorder.entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d: %+v", len(recorder.entries), recorder.entries)
+	}
+
+	var summary *recordedEntry
+	for i, entry := range recorder.entries {
+		if strings.HasPrefix(entry.message, "suppressed") {
+			summary = &recorder.entries[i]
+		}
+	}
+	if summary == nil || summary.message != "suppressed 99 similar messages: redis unavailable" || summary.err != err {
+		t.Errorf("missing or incorrect summary entry: %+v", summary)
+	}
+}
+
+// Test RateLimitedLogger applies per level token bucket limits
+func TestRateLimitedLoggerLimitsLevel(t *testing.T) {
+	recorder := &RecordingLogger{}
+	logger := NewRateLimitedLogger(recorder, RateLimitOptions{
+		DeduplicationWindow: time.Hour,
+		LevelLimits:         map[LogLevel]RateLimit{Warning: {PerSecond: 0.001, Burst: 2}},
+	})
+
+	for _, message := range []string{"one", "two", "three", "four"} {
+		logger.Log(Warning, message, nil, nil)
+		logger.Log(Info, message, nil, nil)
+	}
+	logger.Close(time.Second)
+
+	counts := map[LogLevel]int{}
+	for _, entry := range recorder.entries {
+		counts[entry.level]++
+	}
+
+	// Two warnings within the burst, plus one rate limit summary
+	if counts[Warning] != 3 || counts[Info] != 4 {
+		t.Errorf("unexpected entry counts: %+v", counts)
+	}
+	if last := recorder.entries[len(recorder.entries)-1]; last.message != "suppressed 2 WARNING messages exceeding the rate limit" {
+		t.Errorf("unexpected summary: %s", last.message)
+	}
+}
+
+// Test RateLimitedLogger summarises repeats from every window when an entry is
+// logged continuously across several windows
+func TestRateLimitedLoggerSummarisesEachWindow(t *testing.T) {
+	recorder := &RecordingLogger{}
+	window := 20 * time.Millisecond
+	logger := NewRateLimitedLogger(recorder, RateLimitOptions{DeduplicationWindow: window})
+
+	err := errors.New("connection refused")
+	calls := 0
+	for deadline := time.Now().Add(5 * window); time.Now().Before(deadline); {
+		logger.Log(Error, "redis unavailable", err, nil)
+		calls++
+		time.Sleep(100 * time.Microsecond)
+	}
+
+	recorder.mu.Lock()
+	summariesBeforeClose := 0
+	for _, entry := range recorder.entries {
+		if strings.HasPrefix(entry.message, "suppressed") {
+			summariesBeforeClose++
+		}
+	}
+	recorder.mu.Unlock()
+
+	logger.Close(time.Second)
+
+	if summariesBeforeClose < 3 {
+		t.Errorf("expected a summary for at least 3 windows before Close, got %d", summariesBeforeClose)
+	}
+
+	logged, suppressed := 0, 0
+	for _, entry := range recorder.entries {
+		if entry.message == "redis unavailable" {
+			logged++
+			continue
+		}
+
+		var n int
+		if _, scanErr := fmt.Sscanf(entry.message, "suppressed %d similar messages: redis unavailable", &n); scanErr != nil || entry.err != err {
+			t.Fatalf("unexpected entry: %+v", entry)
+		}
+		suppressed += n
+	}
+
+	if logged+suppressed != calls {
+		t.Errorf("expected %d logged and suppressed entries, got %d logged and %d suppressed", calls, logged, suppressed)
+	}
+}
@@ -0,0 +1,246 @@
+package log
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	serviceerrors "github.com/Adapptor/service/v2/errors"
+)
+
+// Replacement for field values that are not in the allow-list
+const RedactedValue = "[REDACTED]"
+
+// A rule that replaces all matches of a pattern in log messages, errors, user
+// properties and fields
+type RedactionRule struct {
+	// Name of the rule, for reference only
+	Name string
+	// Pattern to match; the replacement may reference capture groups (e.g., "$1")
+	Pattern *regexp.Regexp
+	// Replacement for each match
+	Replacement string
+	// Optional validation of a match; matches failing validation are left unchanged
+	Validate func(match string) bool
+}
+
+// Built-in redaction rules
+var (
+	// Email addresses
+	RedactEmails = RedactionRule{
+		Name:        "email",
+		Pattern:     regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+		Replacement: "[REDACTED EMAIL]",
+	}
+	// Payment card numbers with a known issuer prefix and length, either not
+	// separated or grouped consistently with spaces or dashes, that pass the
+	// Luhn check
+	RedactCardNumbers = RedactionRule{
+		Name:        "card",
+		Pattern:     cardNumberPattern(),
+		Replacement: "[REDACTED CARD]",
+		Validate:    isLuhnValid,
+	}
+	// Bearer tokens and JWTs
+	RedactTokens = RedactionRule{
+		Name:        "token",
+		Pattern:     regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*|\beyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`),
+		Replacement: "[REDACTED TOKEN]",
+	}
+	// Values of key=value or key: value pairs with a secret-like key (e.g., password=hunter2)
+	RedactSecrets = RedactionRule{
+		Name:        "secret",
+		Pattern:     regexp.MustCompile(`(?i)\b((?:api[_\-]?key|access[_\-]?token|token|secret|password|passwd)\s*[=:]\s*)[^\s&,;"']+`),
+		Replacement: "${1}" + RedactedValue,
+	}
+)
+
+// All built-in redaction rules
+var DefaultRedactionRules = []RedactionRule{RedactEmails, RedactCardNumbers, RedactTokens, RedactSecrets}
+
+// A Redactor removes personally identifiable information from log entries
+// before they are sent to any log sink.
+//
+// User properties listed in HashedUserProperties are replaced with a salted
+// hash, so entries for the same user can still be correlated.  All other
+// user properties, fields, messages and errors have the redaction rules applied.
+type Redactor struct {
+	// Rules applied to messages, errors, user properties and field values
+	Rules []RedactionRule
+	// User properties whose values are replaced with a salted hash
+	HashedUserProperties []UserProperty
+	// Salt prepended to user property values before hashing
+	HashSalt string
+	// If not nil, the values of fields not in this list are replaced with RedactedValue
+	AllowedFields []string
+}
+
+// NewRedactor creates a Redactor with the default redaction rules
+func NewRedactor() *Redactor {
+	return &Redactor{Rules: DefaultRedactionRules}
+}
+
+// RedactString applies the redaction rules to the given string
+func (r *Redactor) RedactString(s string) string {
+	for _, rule := range r.Rules {
+		if rule.Validate == nil {
+			s = rule.Pattern.ReplaceAllString(s, rule.Replacement)
+		} else {
+			s = rule.Pattern.ReplaceAllStringFunc(s, func(match string) string {
+				if !rule.Validate(match) {
+					return match
+				}
+				return rule.Pattern.ReplaceAllString(match, rule.Replacement)
+			})
+		}
+	}
+
+	return s
+}
+
+// HashValue returns the salted SHA-256 hash of the given value, truncated for readability
+func (r *Redactor) HashValue(value string) string {
+	hash := sha256.Sum256([]byte(r.HashSalt + value))
+	return "sha256:" + hex.EncodeToString(hash[:8])
+}
+
+// Redact returns a redacted copy of the given message, error and context.
+//
+// The returned context carries redacted copies of the user properties and
+// fields of the given context; the originals are not modified.
+func (r *Redactor) Redact(message string, err error, ctx context.Context) (string, error, context.Context) {
+	message = r.RedactString(message)
+
+	if err != nil {
+		err = &redactedError{
+			message:         r.RedactString(err.Error()),
+			detailedMessage: r.RedactString(fmt.Sprintf("%+v", err)),
+			original:        err,
+		}
+	}
+
+	if ctx == nil {
+		return message, err, ctx
+	}
+
+	if userPropertiesMap := GetUserPropertiesMap(ctx); userPropertiesMap != nil {
+		redactedMap := make(map[UserProperty]string, len(*userPropertiesMap))
+		for userProperty, value := range *userPropertiesMap {
+			if ContainsUserProperty(r.HashedUserProperties, userProperty) {
+				redactedMap[userProperty] = r.HashValue(value)
+			} else {
+				redactedMap[userProperty] = r.RedactString(value)
+			}
+		}
+		ctx = context.WithValue(ctx, UserPropertiesKey, &redactedMap)
+	}
+
+	if fields := GetFields(ctx); fields != nil {
+		redactedFields := make(Fields, len(fields))
+		for key, value := range fields {
+			if r.AllowedFields != nil && !containsString(r.AllowedFields, key) {
+				redactedFields[key] = RedactedValue
+			} else {
+				redactedFields[key] = r.RedactString(value)
+			}
+		}
+		ctx = context.WithValue(ctx, FieldsKey, redactedFields)
+	}
+
+	return message, err, ctx
+}
+
+// An error with redacted messages.  The original error is deliberately not
+// available via errors.Unwrap, as log sinks such as Sentry report the
+// messages of every wrapped error; errors.Is and errors.As match the original
+// error's chain, so its type, code and status are still available.
+type redactedError struct {
+	message         string
+	detailedMessage string
+	original        error
+}
+
+// StackTrace returns the stack of the original error, if available
+func (e *redactedError) StackTrace() []uintptr {
+	return serviceerrors.StackOf(e.original)
+}
+
+// Is reports whether the original error's chain matches the target
+func (e *redactedError) Is(target error) bool {
+	return errors.Is(e.original, target)
+}
+
+// As finds the first error in the original error's chain that matches the target
+func (e *redactedError) As(target any) bool {
+	return errors.As(e.original, target)
+}
+
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+func (e *redactedError) Format(f fmt.State, verb rune) {
+	if verb == 'v' && f.Flag('+') {
+		fmt.Fprint(f, e.detailedMessage)
+	} else {
+		fmt.Fprint(f, e.message)
+	}
+}
+
+// cardNumberPattern returns the pattern matching Visa, Mastercard, American
+// Express, Discover, Diners Club, JCB and UnionPay card numbers, without
+// separators or in the groups printed on the card (e.g., 4-4-4-4 or 4-6-5)
+func cardNumberPattern() *regexp.Regexp {
+	unseparated := `4\d{12}(?:\d{3}){0,2}|5[1-5]\d{14}|2(?:22[1-9]|2[3-9]\d|[3-6]\d\d|7[01]\d|720)\d{12}|` +
+		`3[47]\d{13}|3(?:0[0-5]|[689]\d)\d{11}|35(?:2[89]|[3-8]\d)\d{12}|` +
+		`6011\d{12}|64[4-9]\d{13}|65\d{14}|62\d{14,17}`
+	// Groups separated by S, replaced with each separator
+	grouped := `(?:4\d{3}|5[1-5]\d\d|222[1-9]|22[3-9]\d|2[3-6]\d\d|27[01]\d|2720|35(?:2[89]|[3-8]\d)|6011|64[4-9]\d|65\d\d|62\d\d)(?:S\d{4}){3}(?:S\d{3})?|` +
+		`3[47]\d\dS\d{6}S\d{5}|3(?:0[0-5]|[689]\d)\dS\d{6}S\d{4}`
+
+	alternatives := []string{unseparated}
+	for _, separator := range []string{" ", "-"} {
+		alternatives = append(alternatives, strings.ReplaceAll(grouped, "S", separator))
+	}
+
+	return regexp.MustCompile(`\b(?:` + strings.Join(alternatives, "|") + `)\b`)
+}
+
+// isLuhnValid reports whether the digits in the given string pass the Luhn checksum
+func isLuhnValid(s string) bool {
+	sum := 0
+	double := false
+
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+
+		digit := int(c - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+
+	return sum%10 == 0
+}
+
+func containsString(array []string, value string) bool {
+	for _, a := range array {
+		if a == value {
+			return true
+		}
+	}
+
+	return false
+}
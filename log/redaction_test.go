@@ -0,0 +1,108 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	serviceerrors "github.com/Adapptor/service/v2/errors"
+)
+
+var redactionTestCases = []struct {
+	input    string
+	expected string
+}{
+	{"login failed for jane.doe@example.com", "login failed for [REDACTED EMAIL]"},
+	{"charged card 4111 1111 1111 1111", "charged card [REDACTED CARD]"},
+	{"charged card 4111-1111-1111-1111 ok", "charged card [REDACTED CARD] ok"},
+	{"charged card 5555555555554444", "charged card [REDACTED CARD]"},
+	{"charged card 3782 822463 10005", "charged card [REDACTED CARD]"},
+	{"timestamp 1700000000000 is not a card", "timestamp 1700000000000 is not a card"},
+	{"order 1234567812345670 is not a card", "order 1234567812345670 is not a card"},
+	{"mixed separators 4111 1111-1111 1111", "mixed separators 4111 1111-1111 1111"},
+	{"Authorization: Bearer abc.def-ghi", "Authorization: [REDACTED TOKEN]"},
+	{"token eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxIn0.sig", "token [REDACTED TOKEN]"},
+	{"GET /callback?password=hunter2&next=home", "GET /callback?password=[REDACTED]&next=home"},
+	{"nothing to see here", "nothing to see here"},
+}
+
+func TestRedactString(t *testing.T) {
+	redactor := NewRedactor()
+
+	for _, testCase := range redactionTestCases {
+		if actual := redactor.RedactString(testCase.input); actual != testCase.expected {
+			t.Errorf("RedactString(%q) = %q, expected %q", testCase.input, actual, testCase.expected)
+		}
+	}
+}
+
+// Test millisecond timestamps, which may pass the Luhn check, are not redacted as card numbers
+func TestRedactTimestamp(t *testing.T) {
+	redactor := NewRedactor()
+
+	for i := 0; i < 100; i++ {
+		timestamp := strconv.FormatInt(time.Now().UnixMilli()+int64(i), 10)
+		if actual := redactor.RedactString("at " + timestamp); actual != "at "+timestamp {
+			t.Errorf("expected timestamp %s not to be redacted, got %q", timestamp, actual)
+		}
+	}
+}
+
+// Test Redactor.Redact hashes and redacts user properties and fields without modifying the original context
+func TestRedactContext(t *testing.T) {
+	redactor := NewRedactor()
+	redactor.HashedUserProperties = []UserProperty{UserPropertyId}
+	redactor.AllowedFields = []string{"orderId"}
+
+	userProperties := map[UserProperty]string{UserPropertyId: "42", UserPropertyEmail: "jane.doe@example.com"}
+	ctx := context.WithValue(context.Background(), UserPropertiesKey, &userProperties)
+	ctx = WithFields(ctx, Fields{"orderId": "1234", "address": "1 Hay St"})
+
+	message, err, redactedCtx := redactor.Redact("user jane.doe@example.com", errors.New("no card 4111111111111111"), ctx)
+
+	if message != "user [REDACTED EMAIL]" {
+		t.Errorf("unexpected message: %s", message)
+	}
+	if err.Error() != "no card [REDACTED CARD]" || fmt.Sprintf("%+v", err) != "no card [REDACTED CARD]" {
+		t.Errorf("unexpected error: %+v", err)
+	}
+
+	redactedProperties := *GetUserPropertiesMap(redactedCtx)
+	if redactedProperties[UserPropertyId] != redactor.HashValue("42") || redactedProperties[UserPropertyEmail] != "[REDACTED EMAIL]" {
+		t.Errorf("unexpected user properties: %+v", redactedProperties)
+	}
+	if fields := GetFields(redactedCtx); fields["orderId"] != "1234" || fields["address"] != RedactedValue {
+		t.Errorf("unexpected fields: %+v", fields)
+	}
+	if userProperties[UserPropertyEmail] != "jane.doe@example.com" || GetFields(ctx)["address"] != "1 Hay St" {
+		t.Error("original context was modified")
+	}
+}
+
+// Test redacted errors still match the original error's chain
+func TestRedactErrorChain(t *testing.T) {
+	errNotFound := serviceerrors.New(http.StatusNotFound, "order_not_found", "order not found")
+	original := fmt.Errorf("order for jane.doe@example.com: %w", errNotFound)
+
+	_, err, _ := NewRedactor().Redact("", original, nil)
+
+	if err.Error() != "order for [REDACTED EMAIL]: order not found" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if errors.Unwrap(err) != nil {
+		t.Error("expected the unredacted error not to be unwrapped")
+	}
+	if !errors.Is(err, errNotFound) {
+		t.Error("expected the redacted error to match the original chain")
+	}
+	if serviceerrors.CodeOf(err) != "order_not_found" || serviceerrors.StatusOf(err) != http.StatusNotFound {
+		t.Errorf("expected the code and status of the original error, got %q %d", serviceerrors.CodeOf(err), serviceerrors.StatusOf(err))
+	}
+	if len(serviceerrors.StackOf(err)) == 0 {
+		t.Error("expected the stack of the original error")
+	}
+}
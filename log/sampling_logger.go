@@ -0,0 +1,201 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSamplingInterval   = time.Second
+	DefaultSamplingFirst      = 10
+	DefaultSamplingThereafter = 100
+)
+
+// Options for a SamplingLogger
+type SamplingOptions struct {
+	// Interval over which occurrences of each message are counted; defaults to
+	// DefaultSamplingInterval
+	Interval time.Duration
+	// Number of occurrences of each message logged per interval before sampling;
+	// defaults to DefaultSamplingFirst
+	First int
+	// After the first occurrences, every Thereafter-th occurrence is logged;
+	// defaults to DefaultSamplingThereafter, negative drops all further occurrences
+	Thereafter int
+	// Levels to sample; entries at other levels are always logged.  Defaults to
+	// Trace and Debug.
+	Levels []LogLevel
+	// Returns the key by which occurrences of entries are counted, along with
+	// their level; defaults to SampleByMessage
+	Key func(entry *Entry) string
+}
+
+// SampleByMessage counts occurrences of entries by their message
+func SampleByMessage(entry *Entry) string {
+	return entry.Message
+}
+
+// SampleByCaller counts occurrences of entries by the file and line that
+// logged them, or their message if the caller is unknown, so messages that
+// include variable values are sampled together
+func SampleByCaller(entry *Entry) string {
+	if entry.Caller == nil {
+		return entry.Message
+	}
+	return fmt.Sprintf("%s:%d", entry.Caller.File, entry.Caller.Line)
+}
+
+type samplingKey struct {
+	level LogLevel
+	key   string
+}
+
+// A Logger that wraps another log sink, sampling high volume entries such as
+// debug logs.  Within each interval, the first occurrences of each message are
+// logged, then every Nth occurrence.
+type SamplingLogger struct {
+	logger     Logger
+	interval   time.Duration
+	first      int
+	thereafter int
+	levels     map[LogLevel]bool
+	key        func(entry *Entry) string
+
+	mu          sync.Mutex
+	counts      map[samplingKey]int
+	windowStart time.Time
+}
+
+// NewSamplingLogger wraps the given logger with sampling
+//
+// logger: the log sink to wrap (e.g., a Stackdriver sink)
+// options: sampling rate and levels to sample
+func NewSamplingLogger(logger Logger, options SamplingOptions) *SamplingLogger {
+	interval := options.Interval
+	if interval <= 0 {
+		interval = DefaultSamplingInterval
+	}
+
+	first := options.First
+	if first <= 0 {
+		first = DefaultSamplingFirst
+	}
+
+	thereafter := options.Thereafter
+	if thereafter == 0 {
+		thereafter = DefaultSamplingThereafter
+	}
+
+	levels := options.Levels
+	if len(levels) == 0 {
+		levels = []LogLevel{Trace, Debug}
+	}
+	sampledLevels := make(map[LogLevel]bool)
+	for _, level := range levels {
+		sampledLevels[level] = true
+	}
+
+	key := options.Key
+	if key == nil {
+		key = SampleByMessage
+	}
+
+	return &SamplingLogger{
+		logger:     logger,
+		key:        key,
+		interval:   interval,
+		first:      first,
+		thereafter: thereafter,
+		levels:     sampledLevels,
+		counts:     make(map[samplingKey]int),
+	}
+}
+
+func (l *SamplingLogger) SetMinimumLevel(level LogLevel) {
+	l.logger.SetMinimumLevel(level)
+}
+
+func (l *SamplingLogger) GetMinimumLevel() LogLevel {
+	return l.logger.GetMinimumLevel()
+}
+
+func (l *SamplingLogger) SetUserPropertiesToLog(userPropertiesToLog *[]UserProperty) {
+	l.logger.SetUserPropertiesToLog(userPropertiesToLog)
+}
+
+func (l *SamplingLogger) GetUserPropertiesToLog() *[]UserProperty {
+	return l.logger.GetUserPropertiesToLog()
+}
+
+func (l *SamplingLogger) Log(level LogLevel, message string, err error, ctx context.Context) {
+	if level >= l.logger.GetMinimumLevel() {
+		l.LogEntry(newEntry(level, message, err, ctx, 0, false))
+	}
+}
+
+// Passes sampled entries to the wrapped logger with LogEntry if it is an EntryLogger, otherwise with Log
+func (l *SamplingLogger) LogEntry(entry *Entry) {
+	if entry.Level >= l.logger.GetMinimumLevel() && l.sample(entry) {
+		if entryLogger, ok := l.logger.(EntryLogger); ok {
+			entryLogger.LogEntry(entry)
+		} else {
+			l.logger.Log(entry.Level, entry.Message, entry.Err, entry.Context)
+		}
+	}
+}
+
+func (l *SamplingLogger) Logf(level LogLevel, err error, ctx context.Context, format string, args ...interface{}) {
+	if level >= l.logger.GetMinimumLevel() {
+		l.LogEntry(newEntry(level, fmt.Sprintf(format, args...), err, ctx, 0, false))
+	}
+}
+
+func (l *SamplingLogger) Logln(level LogLevel, err error, ctx context.Context, args ...interface{}) {
+	if level >= l.logger.GetMinimumLevel() {
+		message := fmt.Sprintln(args...)
+
+		// Remove the trailing newline from message as Log writes a newline
+		if len(message) > 0 && message[len(message)-1] == '\n' {
+			message = message[:len(message)-1]
+		}
+
+		l.LogEntry(newEntry(level, message, err, ctx, 0, false))
+	}
+}
+
+// Closes the wrapped logger
+func (l *SamplingLogger) Close(timeout time.Duration) error {
+	return l.logger.Close(timeout)
+}
+
+// sample reports whether an entry should be passed to the wrapped logger
+func (l *SamplingLogger) sample(entry *Entry) bool {
+	if !l.levels[entry.Level] {
+		return true
+	}
+
+	key := samplingKey{level: entry.Level, key: l.key(entry)}
+
+	now := entry.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.windowStart) >= l.interval {
+		l.counts = make(map[samplingKey]int)
+		l.windowStart = now
+	}
+
+	l.counts[key]++
+	count := l.counts[key]
+
+	if count <= l.first {
+		return true
+	}
+	return l.thereafter > 0 && (count-l.first)%l.thereafter == 0
+}
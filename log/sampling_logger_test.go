@@ -0,0 +1,62 @@
+package log
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSamplingLogger(t *testing.T) {
+	recorder := &entryRecordingLogger{}
+	logger := NewSamplingLogger(recorder, SamplingOptions{First: 2, Thereafter: 3, Key: SampleByCaller})
+
+	start := time.Now()
+	traverse := &Caller{File: "/src/json.go", Line: 66}
+	for i := 0; i < 10; i++ {
+		// Messages logged by the same line are sampled together
+		logger.LogEntry(&Entry{Time: start, Level: Debug, Message: fmt.Sprintf("Traversing path %d", i), Caller: traverse})
+	}
+	for i := 0; i < 4; i++ {
+		logger.LogEntry(&Entry{Time: start, Level: Info, Message: "not sampled", Caller: traverse})
+	}
+
+	var messages []string
+	for _, entry := range recorder.logEntries {
+		if entry.Level == Debug {
+			messages = append(messages, entry.Message)
+		}
+	}
+	// The first 2, then every 3rd
+	if fmt.Sprint(messages) != "[Traversing path 0 Traversing path 1 Traversing path 4 Traversing path 7]" {
+		t.Errorf("unexpected sampled messages %v", messages)
+	}
+	if len(recorder.logEntries)-len(messages) != 4 {
+		t.Errorf("expected levels that are not sampled to be logged")
+	}
+
+	// Counts reset in the next interval
+	logger.LogEntry(&Entry{Time: start.Add(DefaultSamplingInterval), Level: Debug, Message: "Traversing path 10", Caller: traverse})
+	if last := recorder.logEntries[len(recorder.logEntries)-1]; last.Message != "Traversing path 10" {
+		t.Errorf("expected the first message of the next interval to be logged, got %q", last.Message)
+	}
+}
+
+// Test distinct messages are sampled separately by default, even when logged by the same line
+func TestSamplingLoggerByMessage(t *testing.T) {
+	recorder := &entryRecordingLogger{}
+	logger := NewSamplingLogger(recorder, SamplingOptions{First: 1, Thereafter: -1})
+
+	start := time.Now()
+	caller := &Caller{File: "/src/orders.go", Line: 42}
+	for _, message := range []string{"order 1 shipped", "order 2 shipped", "order 1 shipped", "order 3 shipped"} {
+		logger.LogEntry(&Entry{Time: start, Level: Debug, Message: message, Caller: caller})
+	}
+
+	var messages []string
+	for _, entry := range recorder.logEntries {
+		messages = append(messages, entry.Message)
+	}
+	if fmt.Sprint(messages) != "[order 1 shipped order 2 shipped order 3 shipped]" {
+		t.Errorf("unexpected sampled messages %v", messages)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"time"
 
+	serviceerrors "github.com/Adapptor/service/v2/errors"
 	"github.com/getsentry/sentry-go"
 )
 
@@ -26,6 +27,15 @@ type SentryLogger struct {
 // minimumLevel: minimum log level
 // userPropertiesToLog: user properties to log (e.g., userId, email)
 func NewSentryLogger(dsn string, debug bool, environment string, release string, tags *map[string]string, minimumLevel LogLevel) (*SentryLogger, error) {
+	return NewSentryLoggerWithTracing(dsn, debug, environment, release, tags, minimumLevel, 0)
+}
+
+// NewSentryLoggerWithTracing creates a new Sentry logger with performance tracing enabled
+//
+// tracesSampleRate: the fraction of transactions to send to Sentry, between 0 and 1; 0 disables tracing
+//
+// See NewSentryLogger for the other parameters.
+func NewSentryLoggerWithTracing(dsn string, debug bool, environment string, release string, tags *map[string]string, minimumLevel LogLevel, tracesSampleRate float64) (*SentryLogger, error) {
 	if err := sentry.Init(sentry.ClientOptions{
 		Dsn:         dsn,
 		Debug:       debug,
@@ -33,6 +43,8 @@ func NewSentryLogger(dsn string, debug bool, environment string, release string,
 		Release:     release,
 		// Ensure stack traces are attached to messages as well as exceptions
 		AttachStacktrace: true,
+		EnableTracing:    tracesSampleRate > 0,
+		TracesSampleRate: tracesSampleRate,
 	}); err != nil {
 		log.Printf("error initialising Sentry: %+v\n", err)
 		return nil, err
@@ -65,19 +77,31 @@ func (l *SentryLogger) SetUserPropertiesToLog(userPropertiesToLog *[]UserPropert
 func (l *SentryLogger) GetUserPropertiesToLog() *[]UserProperty { return l.userPropertiesToLog }
 
 // Log levels below `Warning` are added as breadcrumbs, unless they fall below the configured minimum level.
+//
+// Breadcrumbs and events are sent via the Sentry hub in the context if present
+// (see sentry.SetHubOnContext), otherwise via the global hub.
 func (l *SentryLogger) Log(level LogLevel, message string, err error, ctx context.Context) {
 	if level >= l.minimumLevel {
-		switch level {
+		l.LogEntry(&Entry{Time: time.Now(), Level: level, Message: message, Err: err, Context: ctx})
+	}
+}
+
+// Adds the entry as a breadcrumb or sends it as an event, see Log.  Message
+// events include the entry's stack, if captured.
+func (l *SentryLogger) LogEntry(entry *Entry) {
+	if entry.Level >= l.minimumLevel {
+		switch entry.Level {
 		case Trace, Debug, Info:
 			breadcrumb := sentry.Breadcrumb{
-				Type:     level.String(),
-				Category: "",
-				Data:     nil,
-				Message:  message,
+				Type:      entry.Level.String(),
+				Category:  "",
+				Data:      getFieldsData(entry.Context),
+				Message:   entry.Message,
+				Timestamp: entry.Time,
 			}
-			sentry.AddBreadcrumb(&breadcrumb)
+			getHub(entry.Context).AddBreadcrumb(&breadcrumb, nil)
 		case Warning, Error, Fatal:
-			l.CaptureEvent(message, err, level, ctx)
+			l.captureEntry(entry)
 		}
 	}
 }
@@ -109,15 +133,13 @@ func (l *SentryLogger) Close(timeout time.Duration) error {
 // If the provided context includes user information, it will be associated
 // with this event.
 func (l *SentryLogger) CaptureEvent(message string, err error, level LogLevel, ctx context.Context) {
-	var hub *sentry.Hub = sentry.CurrentHub()
-	sentryLevel := GetSentryLevel(level)
+	l.captureEntry(&Entry{Time: time.Now(), Level: level, Message: message, Err: err, Context: ctx})
+}
 
-	if ctx != nil {
-		// Attempt to get the hub associated with the given context
-		if tempHub := sentry.GetHubFromContext(ctx); tempHub != nil {
-			hub = tempHub
-		}
-	}
+func (l *SentryLogger) captureEntry(entry *Entry) {
+	message, err, level, ctx := entry.Message, entry.Err, entry.Level, entry.Context
+	hub := getHub(ctx)
+	sentryLevel := GetSentryLevel(level)
 
 	// Client is required
 	client := hub.Client()
@@ -131,15 +153,46 @@ func (l *SentryLogger) CaptureEvent(message string, err error, level LogLevel, c
 	if err != nil {
 		event = client.EventFromException(err, sentryLevel)
 		event.Message = message
+		setErrorDetails(event, err)
 	} else {
 		event = client.EventFromMessage(message, sentryLevel)
+		if len(entry.Stack) > 0 {
+			event.Threads = []sentry.Thread{{Stacktrace: sentry.ExtractStacktrace(stackError(entry.Stack)), Current: true}}
+		}
 	}
 
-	// Send event with user if available
-	sentryUser := getUser(ctx, l.userPropertiesToLog)
-	if sentryUser != nil {
+	if fields := getFieldsData(ctx); fields != nil {
+		if event.Contexts == nil {
+			event.Contexts = map[string]sentry.Context{}
+		}
+		event.Contexts["fields"] = fields
+	}
+
+	// Send event with user and trace if available
+	sentryUser, userTags := getUser(ctx, l.userPropertiesToLog)
+	if len(userTags) > 0 {
+		if event.Tags == nil {
+			event.Tags = map[string]string{}
+		}
+		for key, value := range userTags {
+			event.Tags[key] = value
+		}
+	}
+
+	var span *sentry.Span
+	if ctx != nil {
+		span = sentry.SpanFromContext(ctx)
+	}
+
+	if sentryUser != nil || span != nil {
 		hub.WithScope(func(s *sentry.Scope) {
-			s.SetUser(*sentryUser)
+			if sentryUser != nil {
+				s.SetUser(*sentryUser)
+			}
+			if span != nil {
+				// Link the event to the span's trace
+				s.SetSpan(span)
+			}
 			hub.CaptureEvent(event)
 		})
 	} else {
@@ -148,6 +201,45 @@ func (l *SentryLogger) CaptureEvent(message string, err error, level LogLevel, c
 
 }
 
+// Adds details of service errors (see the errors package) to an exception
+// event: the error code as a tag, and the stack where the error originated.
+//
+// Sentry attaches the current stack (i.e., where the error was logged) to the
+// outermost exception if that error has no stack; the stack of the error
+// closest to the origin is used instead, if available.
+func setErrorDetails(event *sentry.Event, err error) {
+	if code := serviceerrors.CodeOf(err); code != "" {
+		if event.Tags == nil {
+			event.Tags = map[string]string{}
+		}
+		event.Tags["error_code"] = code
+	}
+
+	if stackErr, ok := err.(interface{ StackTrace() []uintptr }); (!ok || len(stackErr.StackTrace()) == 0) && len(event.Exception) > 0 {
+		if stack := serviceerrors.StackOf(err); len(stack) > 0 {
+			event.Exception[len(event.Exception)-1].Stacktrace = sentry.ExtractStacktrace(stackError(stack))
+		}
+	}
+}
+
+// A stack in a form that Sentry can extract exception frames from
+type stackError []uintptr
+
+func (e stackError) Error() string         { return "" }
+func (e stackError) StackTrace() []uintptr { return e }
+
+// Returns the Sentry hub associated with the given context, or the global hub
+// if the context has no hub.
+func getHub(ctx context.Context) *sentry.Hub {
+	if ctx != nil {
+		if hub := sentry.GetHubFromContext(ctx); hub != nil {
+			return hub
+		}
+	}
+
+	return sentry.CurrentHub()
+}
+
 // GetSentryLevel Get the Sentry severity level correspodning to the given LogLevel
 func GetSentryLevel(logLevel LogLevel) sentry.Level {
 	switch logLevel {
@@ -167,31 +259,64 @@ func GetSentryLevel(logLevel LogLevel) sentry.Level {
 
 // Examines the supplied context for user properties that can be
 // associated with the log event, and returns a Sentry user, or
-// nil if no user properties are found.
-func getUser(ctx context.Context, userPropertiesToLog *[]UserProperty) *sentry.User {
-	haveUserToLog := false
+// nil if no user properties are found, and the custom user properties
+// registered as Sentry tags.
+//
+// Custom user properties not registered as tags are added to the user's data.
+func getUser(ctx context.Context, userPropertiesToLog *[]UserProperty) (*sentry.User, map[string]string) {
+	userProperties := GetUserPropertiesToLogMap(ctx, userPropertiesToLog)
+	if len(userProperties) == 0 {
+		return nil, nil
+	}
+
 	var sentryUser sentry.User
+	haveUserToLog := false
+	var tags map[string]string
 
-	userPropertiesMap := GetUserPropertiesMap(ctx)
+	for userProperty, value := range userProperties {
+		switch userProperty {
+		case UserPropertyId:
+			sentryUser.ID = value
+		case UserPropertyEmail:
+			sentryUser.Email = value
+		case UserPropertyName:
+			sentryUser.Username = value
+		default:
+			if options, _ := GetUserPropertyOptions(userProperty); options.SentryTag {
+				if tags == nil {
+					tags = map[string]string{}
+				}
+				tags[string(userProperty)] = value
+				continue
+			}
 
-	if userPropertiesMap != nil {
-		if id, ok := (*userPropertiesMap)[UserPropertyId]; ok && ContainsUserProperty(*userPropertiesToLog, UserPropertyId) {
-			sentryUser.ID = id
-			haveUserToLog = true
-		}
-		if email, ok := (*userPropertiesMap)[UserPropertyEmail]; ok && ContainsUserProperty(*userPropertiesToLog, UserPropertyEmail) {
-			sentryUser.Email = email
-			haveUserToLog = true
-		}
-		if userName, ok := (*userPropertiesMap)[UserPropertyName]; ok && ContainsUserProperty(*userPropertiesToLog, UserPropertyName) {
-			sentryUser.Username = userName
-			haveUserToLog = true
+			if sentryUser.Data == nil {
+				sentryUser.Data = map[string]string{}
+			}
+			sentryUser.Data[string(userProperty)] = value
 		}
+		haveUserToLog = true
 	}
 
 	if haveUserToLog {
-		return &sentryUser
+		return &sentryUser, tags
 	} else {
+		return nil, tags
+	}
+}
+
+// Returns the fields from the supplied context as Sentry context data, or nil
+// if no fields are found.
+func getFieldsData(ctx context.Context) map[string]interface{} {
+	fields := GetFields(ctx)
+	if len(fields) == 0 {
 		return nil
 	}
+
+	data := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		data[key] = value
+	}
+
+	return data
 }
@@ -0,0 +1,33 @@
+package log
+
+import (
+	"context"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// Test SentryLogger records breadcrumbs on the hub in the context, so events carry only their own breadcrumbs
+func TestSentryLoggerHubIsolation(t *testing.T) {
+	transport := &sentry.MockTransport{}
+	if err := sentry.Init(sentry.ClientOptions{Dsn: "https://public@example.com/1", Transport: transport}); err != nil {
+		t.Fatal(err)
+	}
+	logger := &SentryLogger{minimumLevel: Info}
+
+	ctx1 := sentry.SetHubOnContext(context.Background(), sentry.CurrentHub().Clone())
+	ctx2 := sentry.SetHubOnContext(context.Background(), sentry.CurrentHub().Clone())
+
+	logger.Log(Info, "request 1 breadcrumb", nil, ctx1)
+	logger.Log(Info, "request 2 breadcrumb", nil, ctx2)
+	logger.Log(Error, "request 1 failed", nil, ctx1)
+
+	events := transport.Events()
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	breadcrumbs := events[0].Breadcrumbs
+	if len(breadcrumbs) != 1 || breadcrumbs[0].Message != "request 1 breadcrumb" {
+		t.Errorf("unexpected breadcrumbs: %+v", breadcrumbs)
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"io"
 	"strings"
 	"sync"
+	"time"
 
 	"cloud.google.com/go/logging"
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
 	"google.golang.org/api/option"
 )
 
@@ -81,20 +83,33 @@ func (l *StackdriverWriter) SetUserPropertiesToLog(userPropertiesToLog *[]UserPr
 func (l *StackdriverWriter) GetUserPropertiesToLog() *[]UserProperty { return l.userPropertiesToLog }
 
 func (l *StackdriverWriter) Log(level LogLevel, message string, err error, ctx context.Context) {
+	if level >= l.minimumLevel {
+		l.LogEntry(newEntry(level, message, err, ctx, 0, false))
+	}
+}
+
+// Logs the entry, with its caller as the source location
+func (l *StackdriverWriter) LogEntry(entry *Entry) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if level >= l.minimumLevel {
-		userProperties := GetUserPropertiesString(ctx, l.userPropertiesToLog)
-		if userProperties != nil {
-			message = fmt.Sprintf("%s (%s)", message, *userProperties)
+	if entry.Level >= l.minimumLevel {
+		stackdriverEntry := logging.Entry{
+			Timestamp: entry.Time,
+			Severity:  logLevelToStackDriverSeverity[entry.Level],
+			Payload:   formatEntry(entry, l.userPropertiesToLog, 0),
+			Labels:    stackdriverLabels(entry.Context, l.userPropertiesToLog),
 		}
 
-		if err == nil {
-			l.Logger.Log(logging.Entry{Severity: logLevelToStackDriverSeverity[level], Payload: fmt.Sprintf("%v", message)})
-		} else {
-			l.Logger.Log(logging.Entry{Severity: logLevelToStackDriverSeverity[level], Payload: fmt.Sprintf("%v, %+v", message, err)})
+		if entry.Caller != nil {
+			stackdriverEntry.SourceLocation = &logpb.LogEntrySourceLocation{
+				File:     entry.Caller.File,
+				Line:     int64(entry.Caller.Line),
+				Function: entry.Caller.Function,
+			}
 		}
+
+		l.Logger.Log(stackdriverEntry)
 	}
 }
 
@@ -119,6 +134,41 @@ func (l *StackdriverWriter) Close() error {
 	return l.Client.Close()
 }
 
+// AsLogger returns the writer as a Logger, e.g., for LoggerSet.AddLogger
+func (l *StackdriverWriter) AsLogger() Logger {
+	return stackdriverLogger{l}
+}
+
+// A StackdriverWriter that implements Logger.Close
+type stackdriverLogger struct {
+	*StackdriverWriter
+}
+
+func (l stackdriverLogger) Close(timeout time.Duration) error {
+	return l.StackdriverWriter.Close()
+}
+
+// stackdriverLabels returns the user properties to log and the fields in the
+// context as Stackdriver labels, or nil if there are none.  User property
+// labels are prefixed with "user." (e.g., "user.tenantId").
+func stackdriverLabels(ctx context.Context, userPropertiesToLog *[]UserProperty) map[string]string {
+	userProperties := GetUserPropertiesToLogMap(ctx, userPropertiesToLog)
+	fields := GetFields(ctx)
+	if len(userProperties) == 0 && len(fields) == 0 {
+		return nil
+	}
+
+	labels := make(map[string]string, len(userProperties)+len(fields))
+	for userProperty, value := range userProperties {
+		labels["user."+string(userProperty)] = value
+	}
+	for key, value := range fields {
+		labels[key] = value
+	}
+
+	return labels
+}
+
 // Deprecated
 var severityMap = map[string]logging.Severity{
 	"TRACE":   DropLog,
@@ -5,8 +5,6 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"runtime"
-	"strings"
 	"time"
 )
 
@@ -44,36 +42,22 @@ func (l *StandardLogger) SetUserPropertiesToLog(userPropertiesToLog *[]UserPrope
 
 func (l *StandardLogger) GetUserPropertiesToLog() *[]UserProperty { return l.userPropertiesToLog }
 
-// logInternal - all public log functions should call this after checking the minimum level
-func (l *StandardLogger) logInternal(level LogLevel, message string, err error, ctx context.Context) {
-	// Get the calling function skipping 4 frames so we can print the actual caller
-	if _, file, line, ok := runtime.Caller(4); ok {
-		lastSlash := strings.LastIndex(file, "/")
-		fileName := file[lastSlash+1:]
-		message = fmt.Sprintf("%s:%d: %s", fileName, line, message)
-	}
-
-	userProperties := GetUserPropertiesString(ctx, l.userPropertiesToLog)
-	if userProperties != nil {
-		message = fmt.Sprintf("%s (%s)", message, *userProperties)
-	}
-
-	if err == nil {
-		l.levelLoggers[level].Println(message)
-	} else {
-		l.levelLoggers[level].Printf("%s, %+v\n", message, err)
+func (l *StandardLogger) Log(level LogLevel, message string, err error, ctx context.Context) {
+	if level >= l.minimumLevel {
+		l.LogEntry(newEntry(level, message, err, ctx, 0, false))
 	}
 }
 
-func (l *StandardLogger) Log(level LogLevel, message string, err error, ctx context.Context) {
-	if level >= l.minimumLevel {
-		l.logInternal(level, message, err, ctx)
+// Logs the entry prefixed with its caller's file and line
+func (l *StandardLogger) LogEntry(entry *Entry) {
+	if entry.Level >= l.minimumLevel {
+		l.levelLoggers[entry.Level].Println(formatEntry(entry, l.userPropertiesToLog, formatCaller|formatFields))
 	}
 }
 
 func (l *StandardLogger) Logf(level LogLevel, err error, ctx context.Context, format string, args ...interface{}) {
 	if level >= l.minimumLevel {
-		l.logInternal(level, fmt.Sprintf(format, args...), err, ctx)
+		l.Log(level, fmt.Sprintf(format, args...), err, ctx)
 	}
 }
 
@@ -86,7 +70,7 @@ func (l *StandardLogger) Logln(level LogLevel, err error, ctx context.Context, a
 			message = message[:len(message)-1]
 		}
 
-		l.logInternal(level, message, err, ctx)
+		l.Log(level, message, err, ctx)
 	}
 }
 
@@ -0,0 +1,378 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A syslog facility, see RFC 5424 section 6.2.1
+type SyslogFacility int
+
+const (
+	FacilityKern SyslogFacility = iota
+	FacilityUser
+	FacilityMail
+	FacilityDaemon
+	FacilityAuth
+	FacilitySyslog
+	FacilityLpr
+	FacilityNews
+	FacilityUucp
+	FacilityCron
+	FacilityAuthPriv
+	FacilityFtp
+)
+
+const (
+	FacilityLocal0 SyslogFacility = iota + 16
+	FacilityLocal1
+	FacilityLocal2
+	FacilityLocal3
+	FacilityLocal4
+	FacilityLocal5
+	FacilityLocal6
+	FacilityLocal7
+)
+
+// Default structured data ID for log fields; enterprise number 32473 is reserved for documentation
+const DefaultSyslogStructuredDataId = "fields@32473"
+
+// Number of messages queued for the background writer; messages logged while
+// the queue is full are dropped
+const syslogQueueSize = 1000
+
+// Timeout to connect to the syslog server and to write each message
+const syslogTimeout = 10 * time.Second
+
+// Map of log levels to syslog severities, see RFC 5424 section 6.2.1
+var logLevelToSyslogSeverity = map[LogLevel]int{
+	Trace:   7, // debug
+	Debug:   7, // debug
+	Info:    6, // informational
+	Warning: 4, // warning
+	Error:   3, // error
+	Fatal:   2, // critical
+}
+
+// A logger that sends RFC 5424 syslog messages over a unix socket, UDP or TCP
+//
+// Fields are sent as structured data parameters.  Messages sent over TCP are
+// framed with octet counting (RFC 6587).
+//
+// Messages are queued and sent from a background goroutine, so logging never
+// blocks on a slow or unreachable syslog server.
+type SyslogLogger struct {
+	network  string
+	address  string
+	facility SyslogFacility
+	appName  string
+	hostname string
+	procId   string
+
+	// Structured data ID for log fields; defaults to DefaultSyslogStructuredDataId
+	StructuredDataId string
+
+	mu                  sync.Mutex
+	conn                net.Conn
+	closed              bool
+	minimumLevel        LogLevel
+	userPropertiesToLog *[]UserProperty
+
+	queue   chan string
+	dropped atomic.Int64
+	wg      sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// NewSyslogLogger creates a syslog logger connected to the given address
+//
+// network: "unix" (datagram or stream), "udp" or "tcp"
+// address: the socket path (e.g., /dev/log) or host:port of the syslog server
+// facility: the syslog facility of all messages
+// appName: the application name of all messages (e.g., the service name)
+// minimumLevel: minimum log level
+func NewSyslogLogger(network string, address string, facility SyslogFacility, appName string, minimumLevel LogLevel) (*SyslogLogger, error) {
+	hostname, _ := os.Hostname()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	l := &SyslogLogger{
+		network:          network,
+		address:          address,
+		facility:         facility,
+		appName:          appName,
+		hostname:         hostname,
+		procId:           fmt.Sprintf("%d", os.Getpid()),
+		StructuredDataId: DefaultSyslogStructuredDataId,
+		minimumLevel:     minimumLevel,
+		queue:            make(chan string, syslogQueueSize),
+		ctx:              ctx,
+		cancel:           cancel,
+	}
+
+	if err := l.connect(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	l.wg.Add(1)
+	go l.run()
+
+	return l, nil
+}
+
+// connect connects to the syslog server; only the background goroutine
+// connects once it has started
+func (l *SyslogLogger) connect() error {
+	dialer := net.Dialer{Timeout: syslogTimeout}
+
+	var conn net.Conn
+	var err error
+
+	switch l.network {
+	case "unix":
+		// Syslog daemons usually listen on a datagram socket, but may use a stream socket
+		if conn, err = dialer.DialContext(l.ctx, "unixgram", l.address); err != nil {
+			conn, err = dialer.DialContext(l.ctx, "unix", l.address)
+		}
+	case "udp", "tcp":
+		conn, err = dialer.DialContext(l.ctx, l.network, l.address)
+	default:
+		err = fmt.Errorf("unsupported syslog network %s", l.network)
+	}
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.conn = conn
+	l.mu.Unlock()
+
+	return nil
+}
+
+func (l *SyslogLogger) SetMinimumLevel(level LogLevel) {
+	l.minimumLevel = level
+}
+
+func (l *SyslogLogger) GetMinimumLevel() LogLevel {
+	return l.minimumLevel
+}
+
+func (l *SyslogLogger) SetUserPropertiesToLog(userPropertiesToLog *[]UserProperty) {
+	l.userPropertiesToLog = userPropertiesToLog
+}
+
+func (l *SyslogLogger) GetUserPropertiesToLog() *[]UserProperty { return l.userPropertiesToLog }
+
+func (l *SyslogLogger) Log(level LogLevel, message string, err error, ctx context.Context) {
+	if level >= l.minimumLevel {
+		l.LogEntry(newEntry(level, message, err, ctx, 0, false))
+	}
+}
+
+// Queues the entry, prefixed with its caller's file and line, to send
+func (l *SyslogLogger) LogEntry(entry *Entry) {
+	if entry.Level >= l.minimumLevel {
+		message := formatEntry(entry, l.userPropertiesToLog, formatCaller)
+		message = l.format(entry.Level, message, GetFields(entry.Context), entry.Time)
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if l.closed {
+			return
+		}
+
+		select {
+		case l.queue <- message:
+		default:
+			l.dropped.Add(1)
+		}
+	}
+}
+
+func (l *SyslogLogger) Logf(level LogLevel, err error, ctx context.Context, format string, args ...interface{}) {
+	if level >= l.minimumLevel {
+		l.Log(level, fmt.Sprintf(format, args...), err, ctx)
+	}
+}
+
+func (l *SyslogLogger) Logln(level LogLevel, err error, ctx context.Context, args ...interface{}) {
+	if level >= l.minimumLevel {
+		l.Log(level, strings.TrimSuffix(fmt.Sprintln(args...), "\n"), err, ctx)
+	}
+}
+
+// Sends the queued messages and closes the connection.  Messages not sent
+// before the timeout are dropped.
+func (l *SyslogLogger) Close(timeout time.Duration) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.queue)
+	l.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+	}
+
+	// Interrupt a connection or write in progress
+	l.cancel()
+	l.mu.Lock()
+	if l.conn != nil {
+		l.conn.SetDeadline(time.Now())
+	}
+	l.mu.Unlock()
+
+	return fmt.Errorf("timed out sending %d queued syslog messages", len(l.queue))
+}
+
+// run sends queued messages until the queue is closed, then closes the connection
+func (l *SyslogLogger) run() {
+	defer l.wg.Done()
+
+	for message := range l.queue {
+		if l.ctx.Err() != nil {
+			// Closing timed out, so drop the remaining messages
+			continue
+		}
+
+		l.write(message)
+
+		if dropped := l.dropped.Swap(0); dropped > 0 {
+			ReportSinkError("syslog", fmt.Errorf("dropped %d messages while the queue was full", dropped))
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+	l.cancel()
+}
+
+// format returns an RFC 5424 syslog message
+func (l *SyslogLogger) format(level LogLevel, message string, fields Fields, timestamp time.Time) string {
+	priority := int(l.facility)*8 + logLevelToSyslogSeverity[level]
+
+	return fmt.Sprintf("<%d>1 %s %s %s %s - %s %s",
+		priority,
+		timestamp.Format(time.RFC3339Nano),
+		syslogHeaderValue(l.hostname),
+		syslogHeaderValue(l.appName),
+		l.procId,
+		l.structuredData(fields),
+		message)
+}
+
+// structuredData returns the structured data element for the given fields, or
+// the nil value "-" if there are no fields
+func (l *SyslogLogger) structuredData(fields Fields) string {
+	if len(fields) == 0 {
+		return "-"
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	builder.WriteString("[" + l.StructuredDataId)
+	for _, key := range keys {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(fields[key])
+		fmt.Fprintf(&builder, ` %s="%s"`, syslogParamName(key), value)
+	}
+	builder.WriteString("]")
+
+	return builder.String()
+}
+
+// write sends the message, reconnecting once if the connection has failed.
+// Only the background goroutine writes, so the connection is used without
+// holding the lock, which is only held to replace the connection.
+func (l *SyslogLogger) write(message string) {
+	if l.network == "tcp" {
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if l.conn == nil {
+			if err = l.connect(); err != nil {
+				continue
+			}
+		}
+
+		l.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		if _, err = l.conn.Write([]byte(message)); err == nil {
+			return
+		}
+
+		l.mu.Lock()
+		l.conn.Close()
+		l.conn = nil
+		l.mu.Unlock()
+
+		if l.ctx.Err() != nil {
+			break
+		}
+	}
+
+	ReportSinkError("syslog", fmt.Errorf("failed to write: %w", err))
+}
+
+// syslogHeaderValue returns the value for a header field, which must be printable
+// US-ASCII without spaces, or the nil value "-" if empty
+func syslogHeaderValue(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, value)
+
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// syslogParamName returns a valid structured data parameter name of at most
+// 32 printable US-ASCII characters, excluding '=', ' ', ']' and '"'
+func syslogParamName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return name
+}
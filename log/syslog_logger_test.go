@@ -0,0 +1,148 @@
+package log
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var syslogMessagePattern = regexp.MustCompile(`^<11>1 \S+ \S+ test-service \d+ - \[fields@32473 order_Id="12\\\]34"\] syslog_logger_test\.go:\d+: payment failed, declined$`)
+
+func testSyslogLogger(t *testing.T, network string, address string, read func() string) {
+	logger, err := NewSyslogLogger(network, address, FacilityUser, "test-service", Info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close(time.Second)
+
+	ctx := WithField(context.Background(), `order"Id`, "12]34")
+	logger.Log(Debug, "below the minimum level", nil, ctx)
+	logger.Log(Error, "payment failed", errorString("declined"), ctx)
+
+	if message := read(); !syslogMessagePattern.MatchString(message) {
+		t.Errorf("unexpected %s syslog message: %s", network, message)
+	}
+}
+
+func TestSyslogLoggerUdp(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	testSyslogLogger(t, "udp", conn.LocalAddr().String(), func() string {
+		buffer := make([]byte, 2048)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, _ := conn.ReadFrom(buffer)
+		return string(buffer[:n])
+	})
+}
+
+func TestSyslogLoggerUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "syslog.sock")
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	testSyslogLogger(t, "unix", path, func() string {
+		buffer := make([]byte, 2048)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, _ := conn.ReadFrom(buffer)
+		return string(buffer[:n])
+	})
+}
+
+func TestSyslogLoggerTcp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Read an octet counted frame
+		reader := bufio.NewReader(conn)
+		length, _ := reader.ReadString(' ')
+		n, _ := strconv.Atoi(strings.TrimSpace(length))
+		message := make([]byte, n)
+		io.ReadFull(reader, message)
+		messages <- string(message)
+	}()
+
+	testSyslogLogger(t, "tcp", listener.Addr().String(), func() string {
+		select {
+		case message := <-messages:
+			return message
+		case <-time.After(5 * time.Second):
+			return ""
+		}
+	})
+}
+
+// Test logging does not block when a TCP syslog server stops reading, and
+// Close returns after its timeout
+func TestSyslogLoggerStalledTcp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// Hold the connection open without reading
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	defer func() {
+		select {
+		case conn := <-accepted:
+			conn.Close()
+		default:
+		}
+	}()
+
+	logger, err := NewSyslogLogger("tcp", listener.Addr().String(), FacilityUser, "test-service", Info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := strings.Repeat("x", 4096)
+	start := time.Now()
+	for i := 0; i < 5000; i++ {
+		logger.Log(Info, message, nil, nil)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected logging not to block on the stalled server, took %v", elapsed)
+	}
+
+	start = time.Now()
+	if err := logger.Close(100 * time.Millisecond); err == nil {
+		t.Error("expected an error closing with unsent messages")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Close to return after its timeout, took %v", elapsed)
+	}
+}
+
+type errorString string
+
+func (e errorString) Error() string { return string(e) }
@@ -2,7 +2,9 @@ package log
 
 import (
 	"context"
+	"fmt"
 	"strings"
+	"sync"
 )
 
 // Key for the map of all user properties added to a context
@@ -20,8 +22,101 @@ const (
 	UserPropertyName UserProperty = "userName"
 )
 
+// The built-in user properties; see RegisteredUserProperties for all user
+// properties, including registered custom properties
 var UserProperties = [...]UserProperty{UserPropertyId, UserPropertyEmail, UserPropertyName}
 
+// Options of a custom user property
+type UserPropertyOptions struct {
+	// Whether Sentry reports the property as a tag, which can be searched,
+	// rather than as user data
+	SentryTag bool
+}
+
+var (
+	userPropertiesMutex sync.RWMutex
+	// Built-in and registered custom user properties
+	registeredUserProperties = UserProperties[:]
+	userPropertyOptions      = map[UserProperty]UserPropertyOptions{}
+)
+
+// RegisterUserProperty registers a custom user property (e.g., tenant ID or
+// role), usually in a package-level variable:
+//
+//	var UserPropertyTenantId = log.RegisterUserProperty("tenantId", log.UserPropertyOptions{SentryTag: true})
+//
+// Like the built-in properties, custom properties are added to a context with
+// WithUserProperty, and logged by sinks with the property in their user
+// properties to log.  Text sinks log custom properties as name=value.
+func RegisterUserProperty(userProperty UserProperty, options UserPropertyOptions) UserProperty {
+	userPropertiesMutex.Lock()
+	defer userPropertiesMutex.Unlock()
+
+	if _, ok := userPropertyOptions[userProperty]; !ok && !ContainsUserProperty(registeredUserProperties, userProperty) {
+		// Copy, so UserProperties is not modified
+		registeredUserProperties = append(registeredUserProperties[:len(registeredUserProperties):len(registeredUserProperties)], userProperty)
+	}
+	userPropertyOptions[userProperty] = options
+
+	return userProperty
+}
+
+// GetUserPropertyOptions returns the options of a registered custom user
+// property, and whether the property is registered
+func GetUserPropertyOptions(userProperty UserProperty) (UserPropertyOptions, bool) {
+	userPropertiesMutex.RLock()
+	defer userPropertiesMutex.RUnlock()
+
+	options, ok := userPropertyOptions[userProperty]
+	return options, ok
+}
+
+// RegisteredUserProperties returns a copy of all user properties: the
+// built-in properties and registered custom properties.  Sinks can log all
+// user properties with:
+//
+//	userPropertiesToLog := log.RegisteredUserProperties()
+//	log.SetUserPropertiesToLog(&userPropertiesToLog)
+//
+// Properties registered after the call are not included in the copy.
+func RegisteredUserProperties() []UserProperty {
+	userPropertiesMutex.RLock()
+	defer userPropertiesMutex.RUnlock()
+
+	return append([]UserProperty(nil), registeredUserProperties...)
+}
+
+// isBuiltInUserProperty reports whether the property is the user id, email or name
+func isBuiltInUserProperty(userProperty UserProperty) bool {
+	return userProperty == UserPropertyId || userProperty == UserPropertyEmail || userProperty == UserPropertyName
+}
+
+// WithUserProperty returns a copy of the context with the given user property
+// added to any user properties already present in the context
+func WithUserProperty(ctx context.Context, userProperty UserProperty, value string) context.Context {
+	return WithUserProperties(ctx, map[UserProperty]string{userProperty: value})
+}
+
+// WithUserProperties returns a copy of the context with the given user
+// properties added to any user properties already present in the context
+func WithUserProperties(ctx context.Context, userProperties map[UserProperty]string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	merged := map[UserProperty]string{}
+	if userPropertiesMap := GetUserPropertiesMap(ctx); userPropertiesMap != nil {
+		for userProperty, value := range *userPropertiesMap {
+			merged[userProperty] = value
+		}
+	}
+	for userProperty, value := range userProperties {
+		merged[userProperty] = value
+	}
+
+	return context.WithValue(ctx, UserPropertiesKey, &merged)
+}
+
 // ContainsUserProperty checks if a UserProperty value exists in an array of UserProperty.
 //
 // It takes in two parameters: an array of UserProperty and a UserProperty value to search for.
@@ -50,7 +145,8 @@ func GetUserPropertiesMap(ctx context.Context) *map[UserProperty]string {
 }
 
 // GetUserPropertiesString returns a string that contains comma-separated user properties
-// that are specified in the given context and userPropertiesToLog slice.
+// that are specified in the given context and userPropertiesToLog slice.  Custom
+// user properties are formatted as name=value.
 //
 // ctx is the context that contains user properties information. userPropertiesToLog
 // is a pointer to a slice of UserProperty that specifies which user properties to log.
@@ -66,6 +162,9 @@ func GetUserPropertiesString(ctx context.Context, userPropertiesToLog *[]UserPro
 
 				for _, userProperty := range *userPropertiesToLog {
 					if userPropertyValue, ok := (*userPropertiesMap)[userProperty]; ok {
+						if !isBuiltInUserProperty(userProperty) {
+							userPropertyValue = fmt.Sprintf("%s=%s", userProperty, userPropertyValue)
+						}
 						tempResult = append(tempResult, userPropertyValue)
 						haveUserToLog = true
 					}
@@ -81,3 +180,24 @@ func GetUserPropertiesString(ctx context.Context, userPropertiesToLog *[]UserPro
 
 	return nil
 }
+
+// GetUserPropertiesToLogMap returns the user properties in the given context
+// that are in the userPropertiesToLog slice, or nil if there are none
+func GetUserPropertiesToLogMap(ctx context.Context, userPropertiesToLog *[]UserProperty) map[UserProperty]string {
+	userPropertiesMap := GetUserPropertiesMap(ctx)
+	if userPropertiesMap == nil || userPropertiesToLog == nil {
+		return nil
+	}
+
+	var result map[UserProperty]string
+	for _, userProperty := range *userPropertiesToLog {
+		if value, ok := (*userPropertiesMap)[userProperty]; ok {
+			if result == nil {
+				result = map[UserProperty]string{}
+			}
+			result[userProperty] = value
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,74 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+var (
+	testUserPropertyTenantId = RegisterUserProperty("tenantId", UserPropertyOptions{SentryTag: true})
+	testUserPropertyRole     = RegisterUserProperty("role", UserPropertyOptions{})
+)
+
+func TestCustomUserProperties(t *testing.T) {
+	ctx := WithUserProperty(context.Background(), UserPropertyId, "42")
+	ctx = WithUserProperties(ctx, map[UserProperty]string{testUserPropertyTenantId: "acme", testUserPropertyRole: "admin"})
+
+	userPropertiesToLog := []UserProperty{UserPropertyId, testUserPropertyTenantId, testUserPropertyRole}
+	if text := GetUserPropertiesString(ctx, &userPropertiesToLog); text == nil || *text != "42, tenantId=acme, role=admin" {
+		t.Errorf("unexpected user properties string %v", text)
+	}
+
+	user, tags := getUser(ctx, &userPropertiesToLog)
+	if user == nil || user.ID != "42" || user.Data["role"] != "admin" {
+		t.Errorf("unexpected Sentry user %+v", user)
+	}
+	if tags["tenantId"] != "acme" || len(tags) != 1 {
+		t.Errorf("expected the tenant to be a Sentry tag, got %v", tags)
+	}
+
+	// Sinks without user properties to log report no user
+	if user, tags := getUser(ctx, nil); user != nil || tags != nil {
+		t.Errorf("expected no Sentry user, got %+v %v", user, tags)
+	}
+
+	labels := stackdriverLabels(WithField(ctx, "orderId", "1234"), &userPropertiesToLog)
+	if labels["user.tenantId"] != "acme" || labels["user.userId"] != "42" || labels["orderId"] != "1234" {
+		t.Errorf("unexpected Stackdriver labels %v", labels)
+	}
+
+	if !ContainsUserProperty(RegisteredUserProperties(), testUserPropertyTenantId) {
+		t.Error("expected registered user properties in RegisteredUserProperties")
+	}
+	if ContainsUserProperty(UserProperties[:], testUserPropertyTenantId) {
+		t.Error("expected only built-in user properties in UserProperties")
+	}
+}
+
+// Test all user properties can be read and logged while properties are registered
+func TestRegisterUserPropertyConcurrently(t *testing.T) {
+	ctx := WithUserProperty(context.Background(), UserPropertyId, "42")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			RegisterUserProperty(UserProperty(fmt.Sprintf("concurrent%d", i)), UserPropertyOptions{})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			userPropertiesToLog := RegisteredUserProperties()
+			if GetUserPropertiesToLogMap(ctx, &userPropertiesToLog)[UserPropertyId] != "42" {
+				t.Error("expected the user ID to be logged")
+				return
+			}
+			GetUserPropertiesString(ctx, &userPropertiesToLog)
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,369 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	DefaultWebhookBatchInterval     = 10 * time.Second
+	DefaultWebhookMaxBatchSize      = 20
+	DefaultWebhookRequestsPerMinute = 6
+	DefaultWebhookMaxRetries        = 3
+	DefaultWebhookInitialBackoff    = time.Second
+)
+
+// Functions available to webhook templates
+//
+// json: encodes a value as JSON, e.g., {"content": {{json .Text}}}
+var WebhookTemplateFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		js, err := json.Marshal(value)
+		return string(js), err
+	},
+}
+
+// Options of a WebhookLogger; zero values use the defaults
+type WebhookOptions struct {
+	// Template of the request body, executed with a WebhookBatch; defaults to a
+	// Slack-compatible incoming webhook payload, {"text": "..."}.  Parse templates
+	// with Funcs(WebhookTemplateFuncs) to use the json function.
+	Template *template.Template
+	// Content type of the request body; defaults to application/json
+	ContentType string
+	// Interval between batches; defaults to DefaultWebhookBatchInterval
+	BatchInterval time.Duration
+	// Maximum entries per batch, a batch is sent early when full; entries beyond
+	// this while waiting for the rate limit are dropped and counted as suppressed.
+	// Defaults to DefaultWebhookMaxBatchSize.
+	MaxBatchSize int
+	// Maximum requests per minute; defaults to DefaultWebhookRequestsPerMinute
+	RequestsPerMinute float64
+	// Maximum retries of failed requests; defaults to DefaultWebhookMaxRetries, negative disables retries
+	MaxRetries int
+	// Delay before the first retry, doubled for each retry; defaults to DefaultWebhookInitialBackoff
+	InitialBackoff time.Duration
+	// HTTP client, e.g., from service.NewHttpClient; defaults to a client with a 10 second timeout
+	Client *http.Client
+}
+
+// An entry posted to a webhook
+type WebhookEntry struct {
+	Time    time.Time
+	Level   LogLevel
+	Message string
+	// Detailed error message, if any
+	Error  string
+	Fields Fields
+	// Short file name and line of the caller, e.g., "server.go:42", if known
+	Caller string
+}
+
+// A batch of entries posted to a webhook in one request
+type WebhookBatch struct {
+	Entries []WebhookEntry
+	// Number of entries dropped by the rate limit since the previous batch
+	Suppressed int
+}
+
+// Text returns the entries of the batch as text, one entry per line
+func (b WebhookBatch) Text() string {
+	var builder strings.Builder
+
+	for i, entry := range b.Entries {
+		if i > 0 {
+			builder.WriteString("\n")
+		}
+		fmt.Fprintf(&builder, "%s: %s", entry.Level.String(), entry.Message)
+		if len(entry.Fields) > 0 {
+			fmt.Fprintf(&builder, " [%s]", entry.Fields.String())
+		}
+		if entry.Error != "" {
+			fmt.Fprintf(&builder, ", %s", entry.Error)
+		}
+	}
+
+	if b.Suppressed > 0 {
+		fmt.Fprintf(&builder, "\n(%d more entries suppressed by the rate limit)", b.Suppressed)
+	}
+
+	return builder.String()
+}
+
+// A logger that posts alerts to a webhook, such as a Slack incoming webhook, by
+// default for Error and Fatal entries.
+//
+// Entries are posted in batches from a background goroutine, so logging never
+// blocks on the webhook.  Requests are rate limited, and failed requests are
+// retried with exponential backoff.
+type WebhookLogger struct {
+	url     string
+	options WebhookOptions
+	limiter *rate.Limiter
+
+	mu                  sync.Mutex
+	pending             []WebhookEntry
+	suppressed          int
+	closed              bool
+	minimumLevel        LogLevel
+	userPropertiesToLog *[]UserProperty
+
+	full   chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewWebhookLogger creates a logger that posts entries at or above the minimum level to the webhook url
+func NewWebhookLogger(url string, minimumLevel LogLevel, options WebhookOptions) *WebhookLogger {
+	if options.ContentType == "" {
+		options.ContentType = "application/json"
+	}
+	if options.BatchInterval <= 0 {
+		options.BatchInterval = DefaultWebhookBatchInterval
+	}
+	if options.MaxBatchSize <= 0 {
+		options.MaxBatchSize = DefaultWebhookMaxBatchSize
+	}
+	if options.RequestsPerMinute <= 0 {
+		options.RequestsPerMinute = DefaultWebhookRequestsPerMinute
+	}
+	if options.MaxRetries < 0 {
+		options.MaxRetries = 0
+	} else if options.MaxRetries == 0 {
+		options.MaxRetries = DefaultWebhookMaxRetries
+	}
+	if options.InitialBackoff <= 0 {
+		options.InitialBackoff = DefaultWebhookInitialBackoff
+	}
+	if options.Client == nil {
+		options.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	l := &WebhookLogger{
+		url:          url,
+		options:      options,
+		limiter:      rate.NewLimiter(rate.Limit(options.RequestsPerMinute/60), 1),
+		minimumLevel: minimumLevel,
+		full:         make(chan struct{}, 1),
+		done:         make(chan struct{}),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+
+	l.wg.Add(1)
+	go l.run()
+
+	return l
+}
+
+func (l *WebhookLogger) SetMinimumLevel(level LogLevel) {
+	l.minimumLevel = level
+}
+
+func (l *WebhookLogger) GetMinimumLevel() LogLevel {
+	return l.minimumLevel
+}
+
+func (l *WebhookLogger) SetUserPropertiesToLog(userPropertiesToLog *[]UserProperty) {
+	l.userPropertiesToLog = userPropertiesToLog
+}
+
+func (l *WebhookLogger) GetUserPropertiesToLog() *[]UserProperty { return l.userPropertiesToLog }
+
+func (l *WebhookLogger) Log(level LogLevel, message string, err error, ctx context.Context) {
+	if level >= l.minimumLevel {
+		l.LogEntry(newEntry(level, message, err, ctx, 0, false))
+	}
+}
+
+// Queues the entry to post in the next batch
+func (l *WebhookLogger) LogEntry(logEntry *Entry) {
+	if logEntry.Level >= l.minimumLevel {
+		message := logEntry.Message
+		userProperties := GetUserPropertiesString(logEntry.Context, l.userPropertiesToLog)
+		if userProperties != nil {
+			message = fmt.Sprintf("%s (%s)", message, *userProperties)
+		}
+
+		entry := WebhookEntry{Time: logEntry.Time, Level: logEntry.Level, Message: message, Fields: GetFields(logEntry.Context)}
+		if logEntry.Err != nil {
+			entry.Error = fmt.Sprintf("%+v", logEntry.Err)
+		}
+		if logEntry.Caller != nil {
+			entry.Caller = logEntry.Caller.String()
+		}
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if l.closed {
+			return
+		}
+
+		if len(l.pending) >= l.options.MaxBatchSize {
+			l.suppressed++
+			return
+		}
+
+		l.pending = append(l.pending, entry)
+		if len(l.pending) == l.options.MaxBatchSize {
+			select {
+			case l.full <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (l *WebhookLogger) Logf(level LogLevel, err error, ctx context.Context, format string, args ...interface{}) {
+	if level >= l.minimumLevel {
+		l.Log(level, fmt.Sprintf(format, args...), err, ctx)
+	}
+}
+
+func (l *WebhookLogger) Logln(level LogLevel, err error, ctx context.Context, args ...interface{}) {
+	if level >= l.minimumLevel {
+		l.Log(level, strings.TrimSuffix(fmt.Sprintln(args...), "\n"), err, ctx)
+	}
+}
+
+// Posts any pending entries, ignoring the rate limit, and stops the background
+// goroutine.  Requests in progress are abandoned after the timeout.
+func (l *WebhookLogger) Close(timeout time.Duration) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+
+	timer := time.AfterFunc(timeout, l.cancel)
+	defer timer.Stop()
+	defer l.cancel()
+
+	close(l.done)
+	l.wg.Wait()
+
+	return l.flush(true)
+}
+
+func (l *WebhookLogger) run() {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(l.options.BatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			l.flush(false)
+		case <-l.full:
+			l.flush(false)
+		}
+	}
+}
+
+// flush posts the pending entries, unless the rate limit is exceeded and force is false
+func (l *WebhookLogger) flush(force bool) error {
+	l.mu.Lock()
+	if len(l.pending) == 0 && l.suppressed == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	if !force && !l.limiter.Allow() {
+		// Pending entries are sent in a later batch
+		l.mu.Unlock()
+		return nil
+	}
+
+	batch := WebhookBatch{Entries: l.pending, Suppressed: l.suppressed}
+	l.pending = nil
+	l.suppressed = 0
+	l.mu.Unlock()
+
+	err := l.post(batch)
+	if err != nil {
+		ReportSinkError("webhook", fmt.Errorf("failed to post %d entries: %w", len(batch.Entries), err))
+	}
+
+	return err
+}
+
+// post sends the batch to the webhook, retrying failures with exponential backoff
+func (l *WebhookLogger) post(batch WebhookBatch) error {
+	body, err := l.body(batch)
+	if err != nil {
+		return err
+	}
+
+	backoff := l.options.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = l.send(body)
+		if err == nil || !retry || attempt >= l.options.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-l.ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// body returns the request body for the batch
+func (l *WebhookLogger) body(batch WebhookBatch) ([]byte, error) {
+	if l.options.Template == nil {
+		return json.Marshal(map[string]string{"text": batch.Text()})
+	}
+
+	var buffer bytes.Buffer
+	if err := l.options.Template.Execute(&buffer, batch); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// send posts the body to the webhook, returning whether a failure should be retried
+func (l *WebhookLogger) send(body []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(l.ctx, http.MethodPost, l.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", l.options.ContentType)
+
+	response, err := l.options.Client.Do(request)
+	if err != nil {
+		return l.ctx.Err() == nil, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+
+	// Client errors other than rate limiting will fail again
+	retry := response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+	return retry, fmt.Errorf("webhook responded %s", response.Status)
+}
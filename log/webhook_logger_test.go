@@ -0,0 +1,117 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// A webhook stub that fails the first failures requests with a 503
+type webhookStub struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	bodies   []string
+}
+
+func (s *webhookStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attempts++
+	if s.attempts <= s.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	s.bodies = append(s.bodies, string(body))
+}
+
+func TestWebhookLoggerRetries(t *testing.T) {
+	stub := &webhookStub{failures: 2}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	logger := NewWebhookLogger(server.URL, Error, WebhookOptions{
+		BatchInterval:  10 * time.Millisecond,
+		InitialBackoff: time.Millisecond,
+		Client:         server.Client(),
+	})
+
+	ctx := WithField(context.Background(), "orderId", "1234")
+	logger.Log(Info, "not an alert", nil, ctx)
+	logger.Log(Error, "payment failed", errorString("card declined"), ctx)
+	logger.Log(Fatal, "database unavailable", nil, nil)
+
+	if err := logger.Close(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+
+	if stub.attempts != 3 || len(stub.bodies) != 1 {
+		t.Fatalf("expected 1 batch after 3 attempts, got %d batches after %d attempts", len(stub.bodies), stub.attempts)
+	}
+
+	var payload map[string]string
+	if err := json.Unmarshal([]byte(stub.bodies[0]), &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "ERROR: payment failed [orderId=1234], card declined\nFATAL: database unavailable"
+	if payload["text"] != expected {
+		t.Errorf("unexpected text %q", payload["text"])
+	}
+}
+
+func TestWebhookLoggerTemplate(t *testing.T) {
+	stub := &webhookStub{}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	logger := NewWebhookLogger(server.URL, Error, WebhookOptions{
+		Template:     template.Must(template.New("discord").Funcs(WebhookTemplateFuncs).Parse(`{"content": {{json .Text}}, "count": {{len .Entries}}}`)),
+		MaxBatchSize: 2,
+		Client:       server.Client(),
+	})
+
+	// The batch is full after 2 entries, so the remaining entries are suppressed
+	// until the batch is sent
+	for i := 0; i < 2; i++ {
+		logger.Log(Error, "queue \"orders\" is backed up", nil, nil)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		stub.mu.Lock()
+		sent := len(stub.bodies)
+		stub.mu.Unlock()
+		if sent > 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	logger.Close(5 * time.Second)
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+
+	if len(stub.bodies) != 1 {
+		t.Fatalf("expected a full batch to be sent before the batch interval, got %d batches", len(stub.bodies))
+	}
+
+	if !strings.HasPrefix(stub.bodies[0], `{"content": "ERROR: queue \"orders\" is backed up\nERROR:`) || !strings.HasSuffix(stub.bodies[0], `"count": 2}`) {
+		t.Errorf("unexpected body %s", stub.bodies[0])
+	}
+}
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Adapptor/service/v2/log"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// Sliding window log: a sorted set of the times of requests within the
+	// window.  Returns {allowed, remaining, retry after ms, reset ms}.
+	slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+
+local allowed = 0
+if count < limit then
+	redis.call("ZADD", KEYS[1], now, now .. ":" .. ARGV[3])
+	redis.call("PEXPIRE", KEYS[1], window)
+	count = count + 1
+	allowed = 1
+end
+
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+local reset = tonumber(oldest[2]) + window - now
+local retry = 0
+if allowed == 0 then
+	retry = reset
+end
+
+return {allowed, limit - count, retry, reset}`)
+
+	// Token bucket: a hash of the tokens and the time they were counted.
+	// Returns {allowed, remaining, retry after ms, reset ms}.
+	tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "time")
+local tokens = tonumber(bucket[1]) or burst
+local last = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + (now - last) * rate)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "time", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate))
+
+return {allowed, math.floor(tokens), retry, math.ceil((burst - tokens) / rate)}`)
+)
+
+// The result of a rate limited request
+type RateLimitResult struct {
+	Allowed bool
+	// Maximum requests in the window, or the burst of a token bucket
+	Limit int64
+	// Requests remaining before requests are limited
+	Remaining int64
+	// Time until a request would be allowed, if limited
+	RetryAfter time.Duration
+	// Time until the limit is fully reset
+	Reset time.Duration
+}
+
+// A rate limiter shared by all instances using the same Redis, implemented
+// with atomic Lua scripts
+type RateLimiter struct {
+	redis  *Redis
+	name   string
+	script *redis.Script
+	args   []interface{}
+	limit  int64
+	// Whether each request is recorded with a unique member, for the sliding window
+	uniqueMember bool
+}
+
+// NewSlidingWindowLimiter creates a rate limiter allowing up to limit requests
+// for each key in any window of the given duration.  The limit must be
+// positive and the window at least a millisecond.
+//
+// name: the name of the limiter, used in the Redis keys of its counters
+func NewSlidingWindowLimiter(redis *Redis, name string, limit int, window time.Duration) (*RateLimiter, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("rate limiter %s: limit must be positive, got %d", name, limit)
+	}
+	if window < time.Millisecond {
+		return nil, fmt.Errorf("rate limiter %s: window must be at least 1ms, got %v", name, window)
+	}
+
+	return &RateLimiter{
+		redis:        redis,
+		name:         name,
+		script:       slidingWindowScript,
+		args:         []interface{}{limit, window.Milliseconds()},
+		limit:        int64(limit),
+		uniqueMember: true,
+	}, nil
+}
+
+// NewTokenBucketLimiter creates a rate limiter allowing a sustained rate of
+// requests per second for each key, with bursts of up to burst requests.  The
+// rate and burst must be positive.
+//
+// name: the name of the limiter, used in the Redis keys of its counters
+func NewTokenBucketLimiter(redis *Redis, name string, perSecond float64, burst int) (*RateLimiter, error) {
+	if !(perSecond > 0) || math.IsInf(perSecond, 1) {
+		return nil, fmt.Errorf("rate limiter %s: rate must be positive and finite, got %v", name, perSecond)
+	}
+	if burst <= 0 {
+		return nil, fmt.Errorf("rate limiter %s: burst must be positive, got %d", name, burst)
+	}
+
+	return &RateLimiter{
+		redis:  redis,
+		name:   name,
+		script: tokenBucketScript,
+		// Tokens per millisecond
+		args:  []interface{}{perSecond / 1000, burst},
+		limit: int64(burst),
+	}, nil
+}
+
+// Allow records a request for the key, e.g., a user ID, and returns whether it
+// is allowed
+func (l *RateLimiter) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	args := l.args
+	if l.uniqueMember {
+		member, err := randomToken()
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+		args = append(args[:len(args):len(args)], member)
+	}
+
+	values, err := l.script.Run(ctx, l.redis, []string{"ratelimit:" + l.name + ":" + key}, args...).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	if len(values) != 4 {
+		return RateLimitResult{}, fmt.Errorf("unexpected rate limit reply %v", values)
+	}
+
+	return RateLimitResult{
+		Allowed:    values[0] == 1,
+		Limit:      l.limit,
+		Remaining:  values[1],
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+		Reset:      time.Duration(values[3]) * time.Millisecond,
+	}, nil
+}
+
+// Returns the rate limit key of a request, or an empty string if the request
+// is not rate limited
+type RateLimitKeyFunc func(r *http.Request) string
+
+// RateLimitByIp limits requests by the IP address of the client.  Behind a
+// proxy, set the request's RemoteAddr to the client address first.
+func RateLimitByIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// RateLimitByUser limits requests by the user ID in the log user properties of
+// the request context; requests without a user ID are not limited
+func RateLimitByUser(r *http.Request) string {
+	if userProperties := log.GetUserPropertiesMap(r.Context()); userProperties != nil {
+		if userId := (*userProperties)[log.UserPropertyId]; userId != "" {
+			return "user:" + userId
+		}
+	}
+	return ""
+}
+
+// RateLimitByApiKey limits requests by the API key in the given header;
+// requests without the header are not limited.  Keys are hashed, so they are
+// not stored in Redis.
+func RateLimitByApiKey(header string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		apiKey := r.Header.Get(header)
+		if apiKey == "" {
+			return ""
+		}
+
+		hash := sha256.Sum256([]byte(apiKey))
+		return "apikey:" + hex.EncodeToString(hash[:16])
+	}
+}
+
+// RateLimitByFirst limits requests by the first non-empty key, e.g., by user
+// for signed in users, otherwise by IP address:
+//
+//	RateLimitByFirst(RateLimitByUser, RateLimitByIp)
+func RateLimitByFirst(keys ...RateLimitKeyFunc) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		for _, key := range keys {
+			if k := key(r); k != "" {
+				return k
+			}
+		}
+		return ""
+	}
+}
+
+// RateLimitHandler wraps a handler to limit the rate of requests by key,
+// responding to limited requests with 429 Too Many Requests and a Retry-After
+// header.  All responses of limited keys have X-RateLimit-Limit,
+// X-RateLimit-Remaining and X-RateLimit-Reset (seconds) headers.
+//
+// Requests are allowed if Redis is unavailable, and the error is logged.
+func RateLimitHandler(next http.Handler, limiter *RateLimiter, key RateLimitKeyFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k := key(r)
+		if k == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		result, err := limiter.Allow(r.Context(), k)
+		if err != nil {
+			log.Log(log.Warning, fmt.Sprintf("rate limiter %s failed, allowing %s %s", limiter.name, r.Method, r.URL.Path), err, r.Context())
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+		w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(result.RetryAfter), 1)))
+			status := http.StatusTooManyRequests
+			WriteHttpError(w, http.StatusText(status), status)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ceilSeconds returns the duration in whole seconds, rounded up
+func ceilSeconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
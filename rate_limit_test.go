@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNewRateLimiterInvalid(t *testing.T) {
+	if _, err := NewSlidingWindowLimiter(nil, "login", 0, time.Minute); err == nil {
+		t.Error("expected an error for a zero limit")
+	}
+	if _, err := NewSlidingWindowLimiter(nil, "login", 10, time.Microsecond); err == nil {
+		t.Error("expected an error for a window shorter than a millisecond")
+	}
+	for _, perSecond := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		if _, err := NewTokenBucketLimiter(nil, "api", perSecond, 10); err == nil {
+			t.Errorf("expected an error for a rate of %v", perSecond)
+		}
+	}
+	if _, err := NewTokenBucketLimiter(nil, "api", 1, 0); err == nil {
+		t.Error("expected an error for a zero burst")
+	}
+}
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	limiter, err := NewSlidingWindowLimiter(r, testKeyPrefix(t, r)+"login", 3, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		result, err := limiter.Allow(ctx, "user:42")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Limit != 3 || result.Remaining != int64(2-i) {
+			t.Errorf("request %d: unexpected result %+v", i, result)
+		}
+	}
+
+	result, err := limiter.Allow(ctx, "user:42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed || result.Remaining != 0 || result.RetryAfter <= 0 || result.RetryAfter > time.Minute {
+		t.Errorf("expected the request to be limited, got %+v", result)
+	}
+
+	// Keys are limited separately
+	if result, err := limiter.Allow(ctx, "user:43"); err != nil || !result.Allowed {
+		t.Errorf("expected another key to be allowed, got %+v, %v", result, err)
+	}
+}
+
+func TestTokenBucketLimiter(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	limiter, err := NewTokenBucketLimiter(r, testKeyPrefix(t, r)+"login", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err := limiter.Allow(ctx, "user:42")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Limit != 2 || result.Remaining != int64(1-i) {
+			t.Errorf("request %d: unexpected result %+v", i, result)
+		}
+	}
+
+	result, err := limiter.Allow(ctx, "user:42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A token is added every second
+	if result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > time.Second || result.Reset <= 0 || result.Reset > 2*time.Second {
+		t.Errorf("expected the request to be limited, got %+v", result)
+	}
+
+	if result, err := limiter.Allow(ctx, "user:43"); err != nil || !result.Allowed {
+		t.Errorf("expected another key to be allowed, got %+v, %v", result, err)
+	}
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	r := testRedis(t)
+	limiter, err := NewSlidingWindowLimiter(r, testKeyPrefix(t, r)+"login", 1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := RateLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), limiter, RateLimitByApiKey("X-Api-Key"))
+
+	serve := func(apiKey string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		if apiKey != "" {
+			request.Header.Set("X-Api-Key", apiKey)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	response := serve("secret")
+	if response.Code != http.StatusNoContent {
+		t.Errorf("expected the first request to be allowed, got %d", response.Code)
+	}
+	if response.Header().Get("X-RateLimit-Limit") != "1" || response.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("unexpected rate limit headers %v", response.Header())
+	}
+	if reset, err := strconv.Atoi(response.Header().Get("X-RateLimit-Reset")); err != nil || reset < 1 || reset > 60 {
+		t.Errorf("unexpected X-RateLimit-Reset %q", response.Header().Get("X-RateLimit-Reset"))
+	}
+
+	response = serve("secret")
+	if response.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the second request to be limited, got %d", response.Code)
+	}
+	if retryAfter, err := strconv.Atoi(response.Header().Get("Retry-After")); err != nil || retryAfter < 1 || retryAfter > 60 {
+		t.Errorf("unexpected Retry-After %q", response.Header().Get("Retry-After"))
+	}
+
+	// Requests without a key are not limited
+	response = serve("")
+	if response.Code != http.StatusNoContent || response.Header().Get("X-RateLimit-Limit") != "" {
+		t.Errorf("expected a request without a key not to be limited, got %d, %v", response.Code, response.Header())
+	}
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+
+	"github.com/Adapptor/service/v2/errors"
+	"github.com/Adapptor/service/v2/log"
+)
+
+// An error recovered from a panic, with the stack of the panicking goroutine
+type PanicError struct {
+	// The value passed to panic
+	Value interface{}
+	// Program counters of the stack at the panic, innermost first
+	Stack []uintptr
+}
+
+// newPanicError creates a PanicError for a recovered value; must be called from the deferred function
+func newPanicError(value interface{}) *PanicError {
+	pcs := make([]uintptr, 64)
+	// Skip runtime.Callers, newPanicError, the deferred function and runtime.gopanic
+	n := runtime.Callers(4, pcs)
+
+	return &PanicError{Value: value, Stack: pcs[:n]}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// StackTrace returns the stack of the panicking goroutine, which Sentry reports as exception frames
+func (e *PanicError) StackTrace() []uintptr {
+	return e.Stack
+}
+
+// Format prints the panic value, with the stack trace for %+v
+func (e *PanicError) Format(f fmt.State, verb rune) {
+	fmt.Fprint(f, e.Error())
+
+	if verb == 'v' && f.Flag('+') {
+		fmt.Fprint(f, errors.FormatStack(e.Stack))
+	}
+}
+
+// RecoveryHandler wraps a handler to recover from panics, logging them with
+// the stack trace to all log sinks and responding with a 500 error.
+//
+// level: the log level of recovered panics, e.g., log.Error or log.Fatal
+// writeJson: whether to respond with a JSON problem body (see WriteErrorResponse) rather than plain text
+//
+// Panics with http.ErrAbortHandler are not recovered, so the server aborts the
+// response as intended.  No response is written if the handler had already
+// started writing the response.
+func RecoveryHandler(next http.Handler, level log.LogLevel, writeJson bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+			if value == http.ErrAbortHandler {
+				panic(value)
+			}
+
+			err := newPanicError(value)
+			log.Log(level, fmt.Sprintf("recovered from panic handling %s %s", r.Method, r.URL.Path), err, r.Context())
+
+			if recorder.status != 0 {
+				return
+			}
+
+			// The status and user message of a panic value do not apply to the
+			// response, so only report a generic error
+			status := http.StatusInternalServerError
+			if writeJson {
+				WriteErrorResponse(w, errors.New(status, "", http.StatusText(status)))
+			} else {
+				WriteHttpError(w, http.StatusText(status), status)
+			}
+		}()
+
+		next.ServeHTTP(recorder, r)
+	})
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/Adapptor/service/v2/errors"
+	"github.com/Adapptor/service/v2/log"
+	"github.com/Adapptor/service/v2/log/logtest"
+)
+
+func TestRecoveryHandlerRepanicsAbortHandler(t *testing.T) {
+	capture := logtest.Capture(t)
+
+	handler := RecoveryHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}), log.Error, false)
+
+	recorder := httptest.NewRecorder()
+	func() {
+		defer func() {
+			if value := recover(); value != http.ErrAbortHandler {
+				t.Errorf("expected http.ErrAbortHandler to be re-panicked, got %v", value)
+			}
+		}()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/stream", nil))
+	}()
+
+	if len(capture.Entries()) != 0 {
+		t.Errorf("expected nothing to be logged, got %v", capture.Entries())
+	}
+	if recorder.Body.Len() != 0 {
+		t.Errorf("expected no response body, got %q", recorder.Body.String())
+	}
+}
+
+func TestRecoveryHandlerRespondsWithPlainText(t *testing.T) {
+	capture := logtest.Capture(t)
+
+	handler := RecoveryHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("nil map")
+	}), log.Error, false)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/orders", nil))
+
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", recorder.Code)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("expected a plain text response, got %q", contentType)
+	}
+	if body := recorder.Body.String(); body != "500 error : Internal Server Error" {
+		t.Errorf("unexpected response body %q", body)
+	}
+
+	entry := capture.RequireLogged(t, log.Error, "recovered from panic handling POST /orders")
+	if entry.Err == nil || entry.Err.Error() != "panic: nil map" {
+		t.Errorf("expected the panic value to be logged, got %v", entry.Err)
+	}
+}
+
+// A ResponseWriter that counts calls to WriteHeader
+type headerCountingWriter struct {
+	*httptest.ResponseRecorder
+	writeHeaderCalls int
+}
+
+func (w *headerCountingWriter) WriteHeader(status int) {
+	w.writeHeaderCalls++
+	w.ResponseRecorder.WriteHeader(status)
+}
+
+func TestRecoveryHandlerKeepsStartedResponse(t *testing.T) {
+	capture := logtest.Capture(t)
+
+	handler := RecoveryHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, "partial")
+		panic("failed mid-response")
+	}), log.Error, true)
+
+	writer := &headerCountingWriter{ResponseRecorder: httptest.NewRecorder()}
+	handler.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/report", nil))
+
+	if writer.writeHeaderCalls != 1 || writer.Code != http.StatusAccepted {
+		t.Errorf("expected only the handler's status to be written, got %d calls and status %d", writer.writeHeaderCalls, writer.Code)
+	}
+	if body := writer.Body.String(); body != "partial" {
+		t.Errorf("expected only the handler's response body, got %q", body)
+	}
+
+	capture.RequireLogged(t, log.Error, "recovered from panic handling GET /report")
+}
+
+func panicWithOrder() (err *PanicError) {
+	defer func() {
+		err = newPanicError(recover())
+	}()
+
+	panic("order 1234")
+}
+
+func TestPanicErrorStack(t *testing.T) {
+	err := panicWithOrder()
+
+	if len(err.Stack) == 0 {
+		t.Fatal("expected a stack")
+	}
+	frame, _ := runtime.CallersFrames(err.Stack).Next()
+	if !strings.HasSuffix(frame.Function, ".panicWithOrder") {
+		t.Errorf("expected the stack to start at the panicking function, got %s", frame.Function)
+	}
+
+	if message := fmt.Sprintf("%v", err); message != "panic: order 1234" {
+		t.Errorf("unexpected message %q", message)
+	}
+
+	detailed := fmt.Sprintf("%+v", err)
+	if !strings.HasPrefix(detailed, "panic: order 1234\n") || !strings.Contains(detailed, ".panicWithOrder\n") || !strings.Contains(detailed, "recovery_test.go:") {
+		t.Errorf("expected the stack trace, got %q", detailed)
+	}
+}
+
+func TestRecoveryHandlerRespondsWithGenericError(t *testing.T) {
+	capture := logtest.Capture(t)
+
+	handler := RecoveryHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(errors.New(http.StatusNotFound, "order_not_found", "order 1234 not found").WithUserMessage("The order could not be found"))
+	}), log.Error, true)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/orders/1234", nil))
+
+	var problem ProblemDetails
+	if err := json.Unmarshal(recorder.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Code != http.StatusInternalServerError || problem.Status != http.StatusInternalServerError || problem.Detail != "" || problem.Code != "" {
+		t.Errorf("expected a generic 500 response, got %d %+v", recorder.Code, problem)
+	}
+
+	entry := capture.RequireLogged(t, log.Error, "recovered from panic handling GET /orders/1234")
+	if errors.CodeOf(entry.Err) != "order_not_found" {
+		t.Errorf("expected the panic value to be logged, got %v", entry.Err)
+	}
+}
@@ -1,91 +1,226 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Adapptor/service/v2/log"
 
+	"github.com/redis/go-redis/v9"
 	"google.golang.org/protobuf/proto"
-	"gopkg.in/redis.v3"
 )
 
+// A Redis client with helpers for caching, stats and streams.  The client is
+// a single node, cluster or sentinel client, see NewRedis.
+//
+// Commands with a context that has a Sentry span are recorded as spans of its
+// transaction, see SentryTracingHandler.
 type Redis struct {
-	*redis.Client
+	redis.UniversalClient
+
+	// In-process cache of values read by the helpers, if enabled
+	local atomic.Pointer[localCache]
 }
 
-// Delete all Redis keys with a given prefix wildcard, e.g. "data:*"
-func (r *Redis) DeleteKeysPrefix(prefix string) (interface{}, error) {
-	script := "return redis.call('del', unpack(redis.call('keys', ARGV[1])))"
-	return r.Eval(script, []string{}, []string{prefix}).Result()
+// NewRedis creates a Redis client with the given options: a sentinel client if
+// MasterName is set, a cluster client if more than one address is set or
+// IsClusterMode is true, otherwise a single node client.  Context deadlines
+// are applied to commands; the given options are not modified.
+func NewRedis(options *redis.UniversalOptions) *Redis {
+	clientOptions := *options
+	clientOptions.ContextTimeoutEnabled = true
+	return NewRedisWithClient(redis.NewUniversalClient(&clientOptions))
 }
 
-// KeyCount returns the number of keys that match the specified Redis pattern.
-func (r *Redis) KeyCount(pattern string) int {
-	var cursor int64
-	var n int
-
-	for {
-		var keys []string
-		var err error
-		cursor, keys, err = r.Scan(cursor, pattern, 10).Result()
-		if err != nil {
-			n = -1
-			break
-		} else {
-			n += len(keys)
+// NewRedisWithClient wraps a go-redis client, adding a hook to trace its
+// commands.  The client may be wrapped more than once, as commands already
+// traced by the hook are not traced again.
+func NewRedisWithClient(client redis.UniversalClient) *Redis {
+	client.AddHook(sentryRedisHook{})
+	return &Redis{UniversalClient: client}
+}
+
+// Default number of keys scanned and deleted per batch by DeleteKeys
+const DefaultDeleteKeysBatchSize = 500
+
+// Options for DeleteKeys
+type DeleteKeysOptions struct {
+	// Number of keys scanned and deleted per batch; defaults to DefaultDeleteKeysBatchSize
+	BatchSize int64
+	// Called after each batch with the total number of keys deleted so far; on a
+	// cluster, masters are scanned concurrently, but calls are not concurrent
+	Progress func(deleted int64)
+}
+
+// DeleteKeys deletes all keys matching a glob pattern (e.g., "data:*") in
+// batches with SCAN and UNLINK, so Redis is not blocked on large keyspaces, and
+// returns the number of keys deleted.  On a cluster, each master is scanned.
+//
+// Deletion stops when the context is done, returning the number of keys
+// deleted so far.
+func (r *Redis) DeleteKeys(ctx context.Context, pattern string, options DeleteKeysOptions) (int64, error) {
+	batchSize := options.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultDeleteKeysBatchSize
+	}
+
+	_, cluster := r.UniversalClient.(*redis.ClusterClient)
+
+	var mu sync.Mutex
+	var deleted int64
+
+	err := r.forEachNode(ctx, func(ctx context.Context, client redis.Cmdable) error {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(ctx, cursor, pattern, batchSize).Result()
+			if err != nil {
+				return err
+			}
+
+			if len(keys) > 0 {
+				count, err := unlinkKeys(ctx, client, keys, cluster)
+				r.invalidateLocal(ctx, keys...)
+
+				mu.Lock()
+				deleted += count
+				if options.Progress != nil {
+					options.Progress(deleted)
+				}
+				mu.Unlock()
+
+				if err != nil {
+					return err
+				}
+			}
+
+			cursor = next
 			if cursor == 0 {
-				break
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
 			}
 		}
+	})
+
+	return deleted, err
+}
+
+// unlinkKeys unlinks the keys and returns the number unlinked.  Keys of a
+// cluster node may be in different slots, so they are unlinked one at a time
+// in a pipeline.
+func unlinkKeys(ctx context.Context, client redis.Cmdable, keys []string, cluster bool) (int64, error) {
+	if !cluster {
+		return client.Unlink(ctx, keys...).Result()
 	}
 
-	return n
+	cmds, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Unlink(ctx, key)
+		}
+		return nil
+	})
+
+	var count int64
+	for _, cmd := range cmds {
+		count += cmd.(*redis.IntCmd).Val()
+	}
+	return count, err
+}
+
+// forEachNode calls fn with each master of a cluster, or with the client itself
+func (r *Redis) forEachNode(ctx context.Context, fn func(ctx context.Context, client redis.Cmdable) error) error {
+	if cluster, ok := r.UniversalClient.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return fn(ctx, client)
+		})
+	}
+
+	return fn(ctx, r.UniversalClient)
+}
+
+// Delete all Redis keys with a given prefix wildcard, e.g. "data:*", and return
+// the number of keys deleted, see DeleteKeys
+func (r *Redis) DeleteKeysPrefix(ctx context.Context, prefix string) (int64, error) {
+	return r.DeleteKeys(ctx, prefix, DeleteKeysOptions{})
+}
+
+// KeyCount returns the number of keys that match the specified Redis pattern.
+// On a cluster, each master is scanned.
+func (r *Redis) KeyCount(ctx context.Context, pattern string) (int64, error) {
+	var mu sync.Mutex
+	var n int64
+
+	err := r.forEachNode(ctx, func(ctx context.Context, client redis.Cmdable) error {
+		iter := client.Scan(ctx, 0, pattern, 100).Iterator()
+		var count int64
+		for iter.Next(ctx) {
+			count++
+		}
+
+		mu.Lock()
+		n += count
+		mu.Unlock()
+
+		return iter.Err()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
 }
 
 // Write a protocol buffer to cache with the provided key and expiry
-func (r *Redis) SetCachedProtobuf(key string, obj proto.Message, expiry time.Duration) error {
+func (r *Redis) SetCachedProtobuf(ctx context.Context, key string, obj proto.Message, expiry time.Duration) error {
 	msg, err := proto.Marshal(obj)
 	if err != nil {
 		return err
 	}
-	r.Set(key, msg, expiry)
+
+	if err := r.Set(ctx, key, msg, expiry).Err(); err != nil {
+		return err
+	}
+	r.invalidateLocal(ctx, key)
 
 	return nil
 }
 
 // Add a protocol buffer to the Redis set at the given key
-func (r *Redis) SAddCachedProtobuf(key string, obj proto.Message) error {
+func (r *Redis) SAddCachedProtobuf(ctx context.Context, key string, obj proto.Message) error {
 	msg, err := proto.Marshal(obj)
 	if err != nil {
 		return err
 	}
-	r.SAdd(key, string(msg))
 
-	return nil
+	return r.SAdd(ctx, key, msg).Err()
 }
 
 // Read a protocol buffer from the cache
-func (r *Redis) GetCachedProtobuf(key string, obj proto.Message) ([]byte, error) {
-	value, err := r.Get(key).Result()
+func (r *Redis) GetCachedProtobuf(ctx context.Context, key string, obj proto.Message) ([]byte, error) {
+	bytesArray, err := r.getBytes(ctx, key)
 	if err != nil {
 		return nil, err
 	}
 
-	bytesArray := []byte(value)
 	err = proto.Unmarshal(bytesArray, obj)
 
 	return bytesArray, err
 }
 
 // Write a HTTP response with content from the cached object with the given key and protocol buffer type
-func (r *Redis) WriteProtobufKey(w http.ResponseWriter, key string, obj proto.Message, writeJson bool) error {
-	bytes, err := r.GetCachedProtobuf(key, obj)
-	if err == redis.Nil {
+func (r *Redis) WriteProtobufKey(ctx context.Context, w http.ResponseWriter, key string, obj proto.Message, writeJson bool) error {
+	bytes, err := r.GetCachedProtobuf(ctx, key, obj)
+	if errors.Is(err, redis.Nil) {
 		http.Error(w, err.Error(), http.StatusNotFound)
 	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -100,9 +235,21 @@ func (r *Redis) WriteProtobufKey(w http.ResponseWriter, key string, obj proto.Me
 	return err
 }
 
-func (r *Redis) WriteCacheProtobufMessage(w http.ResponseWriter, obj proto.Message, cacheKey string, expiry time.Duration, useJson bool) {
-	msg, _ := proto.Marshal(obj)
-	r.Set(cacheKey, msg, expiry)
+// Cache a protocol buffer with the provided key and expiry, and write it to the
+// response, logging any error caching it
+func (r *Redis) WriteCacheProtobufMessage(ctx context.Context, w http.ResponseWriter, obj proto.Message, cacheKey string, expiry time.Duration, useJson bool) {
+	msg, err := proto.Marshal(obj)
+	if err != nil {
+		log.Log(log.Error, "Error marshalling protobuf to cache", err, ctx)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := r.Set(ctx, cacheKey, msg, expiry).Err(); err != nil {
+		log.Log(log.Error, "Error caching protobuf", err, ctx)
+	} else {
+		r.invalidateLocal(ctx, cacheKey)
+	}
 
 	if useJson {
 		WriteJsonResponse(w, obj)
@@ -111,13 +258,13 @@ func (r *Redis) WriteCacheProtobufMessage(w http.ResponseWriter, obj proto.Messa
 	}
 }
 
-func (r *Redis) GetProtobufKey(key string, obj proto.Message) error {
-	value, err := r.Get(key).Result()
+func (r *Redis) GetProtobufKey(ctx context.Context, key string, obj proto.Message) error {
+	value, err := r.getBytes(ctx, key)
 	if err != nil {
 		return err
 	}
 
-	err = proto.Unmarshal([]byte(value), obj)
+	err = proto.Unmarshal(value, obj)
 	if err != nil {
 		return err
 	}
@@ -125,22 +272,28 @@ func (r *Redis) GetProtobufKey(key string, obj proto.Message) error {
 	return nil
 }
 
-// Save a json object to Redis
-func (r *Redis) CacheJson(key string, value interface{}, expiry time.Duration) {
+// Save a json object to Redis, logging any error
+//
+// Deprecated: use a Cache with a JsonCodec, which returns errors
+func (r *Redis) CacheJson(ctx context.Context, key string, value interface{}, expiry time.Duration) {
 	jsonData, err := json.Marshal(value)
 	if err != nil {
-		log.Log(log.Error, "Error marshalling to cache", err, nil)
+		log.Log(log.Error, "Error marshalling to cache", err, ctx)
+		return
+	}
+
+	if err := r.Set(ctx, key, string(jsonData[:]), expiry).Err(); err != nil {
+		log.Log(log.Error, "Error caching json", err, ctx)
 	} else {
-		r.Set(key, string(jsonData[:]), 0)
-		if expiry > 0 {
-			r.Expire(key, expiry)
-		}
+		r.invalidateLocal(ctx, key)
 	}
 }
 
-// Read a protocol buffer from the cache
-func (r *Redis) ReadJson(key string, result interface{}) error {
-	data, err := r.Get(key).Bytes()
+// Read a json object from the cache
+//
+// Deprecated: use a Cache with a JsonCodec, which returns ErrCacheNotFound for missing keys
+func (r *Redis) ReadJson(ctx context.Context, key string, result interface{}) error {
+	data, err := r.getBytes(ctx, key)
 	if err != nil {
 		return err
 	}
@@ -148,63 +301,170 @@ func (r *Redis) ReadJson(key string, result interface{}) error {
 }
 
 type CacheWriter struct {
+	ctx    context.Context
 	key    string
-	redis  *redis.Client
+	redis  *Redis
 	expiry time.Duration
 }
 
 func (cw CacheWriter) Write(p []byte) (n int, err error) {
 	//  append to key
-	str, err := cw.redis.Get(cw.key).Result()
+	str, err := cw.redis.Get(cw.ctx, cw.key).Result()
 	if err != nil {
 		return 0, err
 	}
 
 	str += string(p[:])
-	cw.redis.Set(cw.key, str, cw.expiry)
+	if err := cw.redis.Set(cw.ctx, cw.key, str, cw.expiry).Err(); err != nil {
+		return 0, err
+	}
+	cw.redis.invalidateLocal(cw.ctx, cw.key)
 
 	return len(p), nil
 }
 
-// Returns a Writer that will output to a Redis key
-func (r *Redis) CacheKeyWriter(key string, expiry time.Duration) io.Writer {
-	cw := CacheWriter{key: key, redis: r.Client, expiry: expiry}
-	r.Set(key, "", expiry)
+// Returns a Writer that will output to a Redis key, logging any error clearing
+// the key; errors appending to the key are returned by Write
+func (r *Redis) CacheKeyWriter(ctx context.Context, key string, expiry time.Duration) io.Writer {
+	cw := CacheWriter{ctx: ctx, key: key, redis: r, expiry: expiry}
+	if err := r.Set(ctx, key, "", expiry).Err(); err != nil {
+		log.Log(log.Error, "Error clearing cache key", err, ctx)
+	} else {
+		r.invalidateLocal(ctx, key)
+	}
 	return cw
 }
 
-func (r *Redis) RecordTimeKey(key string) {
-	r.Set(key, PerthNow().String(), 0)
+// Set a key to the current time
+func (r *Redis) RecordTimeKey(ctx context.Context, key string) error {
+	if err := r.Set(ctx, key, PerthNow().String(), 0).Err(); err != nil {
+		return err
+	}
+	r.invalidateLocal(ctx, key)
+	return nil
 }
 
-// Remove all Redis cache entries matching a glob pattern
-func (r *Redis) ClearRedisKeys(glob string) error {
-
-	_, err := r.Eval("return redis.call('del', unpack(redis.call('keys', ARGV[1])))",
-		[]string{},
-		[]string{glob}).Result()
-
-	return err
+// Remove all Redis cache entries matching a glob pattern, and return the number
+// of entries removed, see DeleteKeys
+func (r *Redis) ClearRedisKeys(ctx context.Context, glob string) (int64, error) {
+	return r.DeleteKeys(ctx, glob, DeleteKeysOptions{})
 }
 
-func (r *Redis) StatRecordIncr(key string, score float64, member string) error {
-	_, err := r.ZIncrBy(key, score, member).Result()
-	return err
+func (r *Redis) StatRecordIncr(ctx context.Context, key string, score float64, member string) error {
+	return r.ZIncrBy(ctx, key, score, member).Err()
 }
 
-func (r *Redis) StatRevRange(key string) ([]string, error) {
-	r.ZRemRangeByRank(key, 0, -100)
-	return r.ZRevRange(key, 0, 100).Result()
+func (r *Redis) StatRevRange(ctx context.Context, key string) ([]string, error) {
+	if err := r.ZRemRangeByRank(ctx, key, 0, -100).Err(); err != nil {
+		return nil, err
+	}
+	return r.ZRevRange(ctx, key, 0, 100).Result()
 }
 
-func (r *Redis) StatRecordHourValue(key string, value string) {
+func (r *Redis) StatRecordHourValue(ctx context.Context, key string, value string) error {
 	now := PerthNow()
 
 	cacheKey := fmt.Sprintf("%v:%v", key, now.Format("20060102"))
 	hourKey := now.Format("15")
 
-	r.HSet(cacheKey, hourKey, value)
-	r.Expire(cacheKey, 24*time.Hour)
+	if err := r.HSet(ctx, cacheKey, hourKey, value).Err(); err != nil {
+		return err
+	}
+	return r.Expire(ctx, cacheKey, 24*time.Hour).Err()
+}
+
+// An entry of a Redis stream
+type StreamEntry struct {
+	// Entry ID, e.g., "1526919030474-55"
+	Id     string
+	Values map[string]string
+}
+
+// Append an entry to a Redis stream, trimming the stream to approximately
+// maxLength entries if maxLength is positive, and return the entry ID
+func (r *Redis) AddStreamEntry(ctx context.Context, stream string, maxLength int64, values map[string]string) (string, error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fieldValues := make([]string, 0, 2*len(values))
+	for _, key := range keys {
+		fieldValues = append(fieldValues, key, values[key])
+	}
+
+	return r.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: maxLength,
+		Approx: maxLength > 0,
+		Values: fieldValues,
+	}).Result()
+}
+
+// Read up to count entries of a Redis stream after the given entry ID ("0" for
+// the start of the stream), waiting up to block for entries if there are none,
+// or without blocking if block is zero
+func (r *Redis) ReadStreamEntries(ctx context.Context, stream string, afterId string, count int64, block time.Duration) ([]StreamEntry, error) {
+	if block <= 0 {
+		block = -1
+	}
+
+	streams, err := r.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{stream, afterId},
+		Count:   count,
+		Block:   block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		// No entries after the given ID
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The reply has one stream for the single stream read
+	if len(streams) == 0 {
+		return nil, nil
+	}
+
+	return streamEntries(streams[0].Messages), nil
+}
+
+// Return the ID of the last entry of a Redis stream, or "0" if the stream is empty
+func (r *Redis) LastStreamEntryId(ctx context.Context, stream string) (string, error) {
+	messages, err := r.XRevRangeN(ctx, stream, "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+
+	if len(messages) == 0 {
+		return "0", nil
+	}
+
+	return messages[0].ID, nil
+}
+
+// streamEntries converts go-redis stream messages to stream entries
+func streamEntries(messages []redis.XMessage) []StreamEntry {
+	entries := make([]StreamEntry, 0, len(messages))
+	for _, message := range messages {
+		values := make(map[string]string, len(message.Values))
+		for key, value := range message.Values {
+			values[key] = fmt.Sprint(value)
+		}
+
+		entries = append(entries, StreamEntry{Id: message.ID, Values: values})
+	}
+
+	return entries
+}
+
+// ignoreNil returns nil for a redis.Nil error, so a missing key is not traced as a failure
+func ignoreNil(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
 }
 
 func ReadProtobuf(reader io.Reader, message proto.Message) error {
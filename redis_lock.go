@@ -0,0 +1,282 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DefaultLockTTL           = 30 * time.Second
+	DefaultLockRetryInterval = 100 * time.Millisecond
+)
+
+var (
+	// Error returned when a lock is held by another holder
+	ErrLockNotAcquired = errors.New("redis: lock not acquired")
+	// Error returned when releasing a lock whose lease was lost
+	ErrLockNotHeld = errors.New("redis: lock not held")
+)
+
+var (
+	// Sets the lock if not held, and increments the fencing token of the lock
+	acquireLockScript = redis.NewScript(`
+if redis.call("set", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("incr", KEYS[2])
+end
+return 0`)
+
+	// Extends the lease of a lock only if it is still held with the given token
+	renewLockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`)
+
+	// Deletes a lock only if it is still held with the given token
+	releaseLockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+)
+
+// Options for a lock; zero values use the defaults
+type LockOptions struct {
+	// Lease of the lock, after which it is released if not renewed; defaults to DefaultLockTTL
+	TTL time.Duration
+	// Interval between renewals of the lease; defaults to a third of the TTL,
+	// negative disables renewal, so the lock is lost when the TTL elapses
+	RenewInterval time.Duration
+	// Maximum time to wait for the lock if it is held; by default Lock returns
+	// ErrLockNotAcquired immediately
+	Wait time.Duration
+	// Interval between attempts to acquire the lock while waiting; defaults to DefaultLockRetryInterval
+	RetryInterval time.Duration
+}
+
+// A distributed lock held in Redis, see Redis.Lock
+type Lock struct {
+	redis *Redis
+	key   string
+	value string
+	ttl   time.Duration
+	token int64
+
+	lost     chan struct{}
+	lostOnce sync.Once
+	// Reports the lease lost unless stopped by a renewal or release; mu
+	// serialises stopping and resetting it
+	lostTimer *time.Timer
+	mu        sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Lock acquires the named lock, with a lease that is renewed in the background
+// until the lock is released.  If the lock is held, Lock waits up to
+// options.Wait for it, then returns ErrLockNotAcquired.
+//
+// Each acquisition of a lock has a greater fencing token than the previous one,
+// see Lock.Token.
+func (r *Redis) Lock(ctx context.Context, name string, options LockOptions) (*Lock, error) {
+	ttl := options.TTL
+	if ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
+
+	retryInterval := options.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = DefaultLockRetryInterval
+	}
+
+	value, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// The lock and its fencing token share a hash slot on a cluster
+	key := "lock:{" + name + "}"
+	l := &Lock{
+		redis: r,
+		key:   key,
+		value: value,
+		ttl:   ttl,
+		lost:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	deadline := time.Now().Add(options.Wait)
+	var acquired time.Time
+	for {
+		acquired = time.Now()
+		token, err := acquireLockScript.Run(ctx, r, []string{key, key + ":fencing"}, l.value, ttl.Milliseconds()).Int64()
+		if err != nil {
+			return nil, err
+		}
+		if token > 0 {
+			l.token = token
+			break
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, ErrLockNotAcquired
+		}
+
+		// The last attempt is made at the deadline
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(min(retryInterval, remaining)):
+		}
+	}
+
+	l.lostTimer = time.AfterFunc(time.Until(l.lostDeadline(acquired)), l.lose)
+
+	renewInterval := options.RenewInterval
+	if renewInterval == 0 {
+		renewInterval = ttl / 3
+	}
+	if renewInterval > 0 {
+		go l.renew(renewInterval)
+	}
+
+	return l, nil
+}
+
+// randomToken returns a random hex string identifying a lock holder
+func randomToken() (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(random), nil
+}
+
+// Token returns the fencing token of this acquisition of the lock, which
+// increases with each acquisition.  Pass it to resources protected by the lock,
+// so they can reject writes with a token lower than one already seen, e.g.,
+// from a holder that paused and lost its lease.
+func (l *Lock) Token() int64 {
+	return l.token
+}
+
+// Lost returns a channel that is closed if the lease of the lock is lost,
+// because it could not be renewed before it expires, or if renewal is
+// disabled, because the TTL is elapsing before the lock was released.
+//
+// The lease is reported lost a tenth of the TTL before it can expire, so work
+// protected by the lock can stop before another holder acquires it.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// lostDeadline returns the time to report the lease lost, if it is not renewed
+// again, for a lease set by a command sent at the given time.  Redis sets the
+// TTL after the command is sent, so the lease expires no earlier than sent+TTL.
+func (l *Lock) lostDeadline(sent time.Time) time.Time {
+	return sent.Add(l.ttl - l.ttl/10)
+}
+
+// lose reports the lease lost
+func (l *Lock) lose() {
+	l.lostOnce.Do(func() { close(l.lost) })
+}
+
+// renew extends the lease periodically until the lock is released or lost.
+// While Redis is unavailable, the lost timer reports the lease lost if it is
+// not renewed in time.
+func (l *Lock) renew(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-l.lost:
+			return
+		case <-ticker.C:
+		}
+
+		sent := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		result, err := renewLockScript.Run(ctx, l.redis, []string{l.key}, l.value, l.ttl.Milliseconds()).Int64()
+		cancel()
+
+		if err == nil && result == 1 {
+			l.mu.Lock()
+			// Stop fails if the lease was reported lost or the lock released during renewal
+			renewed := l.lostTimer.Stop()
+			if renewed {
+				l.lostTimer.Reset(time.Until(l.lostDeadline(sent)))
+			}
+			l.mu.Unlock()
+
+			if !renewed {
+				return
+			}
+		} else if err == nil {
+			// The lock is held by another holder
+			l.lose()
+			return
+		}
+	}
+}
+
+// Release stops renewing the lease and releases the lock, returning
+// ErrLockNotHeld if the lease was lost
+func (l *Lock) Release(ctx context.Context) error {
+	l.closeOnce.Do(func() {
+		l.mu.Lock()
+		l.lostTimer.Stop()
+		l.mu.Unlock()
+		close(l.done)
+	})
+
+	result, err := releaseLockScript.Run(ctx, l.redis, []string{l.key}, l.value).Int64()
+	if err != nil {
+		return err
+	}
+	if result == 0 {
+		return ErrLockNotHeld
+	}
+
+	return nil
+}
+
+// WithLock calls fn while holding the named lock, with a context that is
+// cancelled if the lease is lost, and releases the lock when fn returns.  If the
+// lock is held, WithLock returns ErrLockNotAcquired without calling fn, e.g.,
+// so a job scheduled on every instance runs on only one.
+func (r *Redis) WithLock(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	lock, err := r.Lock(ctx, name, LockOptions{})
+	if err != nil {
+		return err
+	}
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-lock.Lost():
+			cancel()
+		case <-lockCtx.Done():
+		}
+	}()
+
+	err = fn(lockCtx)
+	releaseErr := lock.Release(context.WithoutCancel(ctx))
+	if err != nil {
+		return err
+	}
+
+	return releaseErr
+}
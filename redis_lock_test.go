@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	name := testKeyPrefix(t, r) + "lock"
+
+	lock, err := r.Lock(ctx, name, LockOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Lock(ctx, name, LockOptions{}); !errors.Is(err, ErrLockNotAcquired) {
+		t.Errorf("expected ErrLockNotAcquired while the lock is held, got %v", err)
+	}
+
+	// Waiting gives up after the wait
+	start := time.Now()
+	if _, err := r.Lock(ctx, name, LockOptions{Wait: 200 * time.Millisecond, RetryInterval: 50 * time.Millisecond}); !errors.Is(err, ErrLockNotAcquired) {
+		t.Errorf("expected ErrLockNotAcquired after waiting, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected Lock to wait for the lock, returned after %v", elapsed)
+	}
+
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := r.Lock(ctx, name, LockOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Release(ctx)
+
+	if next.Token() <= lock.Token() {
+		t.Errorf("expected the fencing token to increase, got %d after %d", next.Token(), lock.Token())
+	}
+}
+
+// Test a waiting Lock acquires the lock when it is released
+func TestLockWait(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	name := testKeyPrefix(t, r) + "lock"
+
+	lock, err := r.Lock(ctx, name, LockOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		lock.Release(ctx)
+	}()
+
+	next, err := r.Lock(ctx, name, LockOptions{Wait: 5 * time.Second, RetryInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next.Release(ctx)
+}
+
+// Test a waiting Lock makes a last attempt at the end of a wait shorter than
+// the retry interval
+func TestLockWaitShorterThanRetryInterval(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	name := testKeyPrefix(t, r) + "lock"
+
+	lock, err := r.Lock(ctx, name, LockOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		lock.Release(ctx)
+	}()
+
+	next, err := r.Lock(ctx, name, LockOptions{Wait: 200 * time.Millisecond, RetryInterval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next.Release(ctx)
+}
+
+// Test a lock whose lease was lost is reported lost and cannot be released
+func TestLockLost(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	name := testKeyPrefix(t, r) + "lock"
+
+	lock, err := r.Lock(ctx, name, LockOptions{RenewInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another holder acquires the lock after the lease expires
+	if err := r.Set(ctx, "lock:{"+name+"}", "other holder", time.Minute).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-lock.Lost():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the lock to be lost")
+	}
+
+	if err := lock.Release(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld, got %v", err)
+	}
+	if holder, err := r.Get(ctx, "lock:{"+name+"}").Result(); err != nil || holder != "other holder" {
+		t.Errorf("expected the other holder's lock to be kept, got %q, %v", holder, err)
+	}
+}
+
+// Test a lock without renewal is reported lost when its TTL elapses, unless it
+// is released first
+func TestLockLostWithoutRenewal(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	prefix := testKeyPrefix(t, r)
+
+	lock, err := r.Lock(ctx, prefix+"expiring", LockOptions{TTL: 50 * time.Millisecond, RenewInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-lock.Lost():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the lock to be lost when its TTL elapsed")
+	}
+
+	released, err := r.Lock(ctx, prefix+"released", LockOptions{TTL: 50 * time.Millisecond, RenewInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := released.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-released.Lost():
+		t.Error("expected a released lock not to be lost")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Test WithLock cancels fn's context with the caller's, and releases the lock
+func TestWithLockCancelled(t *testing.T) {
+	r := testRedis(t)
+	name := testKeyPrefix(t, r) + "lock"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := r.WithLock(ctx, name, func(ctx context.Context) error {
+		if err := r.WithLock(ctx, name, func(ctx context.Context) error { return nil }); !errors.Is(err, ErrLockNotAcquired) {
+			t.Errorf("expected ErrLockNotAcquired while the lock is held, got %v", err)
+		}
+
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected fn's context to be cancelled, got %v", err)
+	}
+
+	called := false
+	if err := r.WithLock(context.Background(), name, func(ctx context.Context) error {
+		called = true
+		return nil
+	}); err != nil || !called {
+		t.Errorf("expected the lock to be released, got %v", err)
+	}
+}
+
+// Test the lease is reported lost before Redis expires the lock, so another
+// holder cannot acquire it while work protected by the lock continues
+func TestLockLostBeforeExpiry(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	name := testKeyPrefix(t, r) + "expiring"
+
+	lock, err := r.Lock(ctx, name, LockOptions{TTL: time.Second, RenewInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-lock.Lost():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the lock to be lost before its TTL elapsed")
+	}
+
+	if _, err := r.Lock(ctx, name, LockOptions{}); !errors.Is(err, ErrLockNotAcquired) {
+		t.Errorf("expected the lock to still be held when reported lost, got %v", err)
+	}
+}
@@ -0,0 +1,384 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Adapptor/service/v2/log"
+)
+
+// Default approximate maximum length of a log stream
+const DefaultLogStreamMaxLength = 10000
+
+// Number of entries queued for the background writer of a RedisStreamLogger;
+// entries logged while the queue is full are dropped
+const logStreamQueueSize = 1000
+
+// Timeout of each command appending an entry to a log stream
+const logStreamWriteTimeout = 5 * time.Second
+
+// Names of the values of log stream entries; fields are stored with the
+// LogStreamFieldPrefix (e.g., "field.orderId")
+const (
+	LogStreamTime        = "time"
+	LogStreamLevel       = "level"
+	LogStreamService     = "service"
+	LogStreamMessage     = "message"
+	LogStreamError       = "error"
+	LogStreamUser        = "user"
+	LogStreamCaller      = "caller"
+	LogStreamFieldPrefix = "field."
+)
+
+// A logger that appends entries to a Redis stream, giving services that share
+// a Redis a central log, see TailLogStream
+//
+// Entries are queued and appended from a background goroutine, so logging
+// never blocks on a slow or unreachable Redis.
+type RedisStreamLogger struct {
+	redis       *Redis
+	stream      string
+	serviceName string
+	maxLength   int64
+
+	minimumLevel        log.LogLevel
+	userPropertiesToLog *[]log.UserProperty
+
+	mu      sync.Mutex
+	closed  bool
+	queue   chan map[string]string
+	dropped atomic.Int64
+	done    chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// NewRedisStreamLogger creates a logger that appends entries to the given stream
+//
+// stream: the key of the stream, shared by all services logging to it
+// serviceName: the name of the service, stored with each entry
+// maxLength: the approximate maximum length of the stream, older entries are trimmed; e.g., DefaultLogStreamMaxLength
+// minimumLevel: minimum log level
+func NewRedisStreamLogger(redis *Redis, stream string, serviceName string, maxLength int64, minimumLevel log.LogLevel) *RedisStreamLogger {
+	// Appending entries is not traced, so it uses a new context
+	ctx, cancel := context.WithCancel(context.Background())
+
+	l := &RedisStreamLogger{
+		redis:        redis,
+		stream:       stream,
+		serviceName:  serviceName,
+		maxLength:    maxLength,
+		minimumLevel: minimumLevel,
+		queue:        make(chan map[string]string, logStreamQueueSize),
+		done:         make(chan struct{}),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+
+	go l.run()
+
+	return l
+}
+
+func (l *RedisStreamLogger) SetMinimumLevel(level log.LogLevel) {
+	l.minimumLevel = level
+}
+
+func (l *RedisStreamLogger) GetMinimumLevel() log.LogLevel {
+	return l.minimumLevel
+}
+
+func (l *RedisStreamLogger) SetUserPropertiesToLog(userPropertiesToLog *[]log.UserProperty) {
+	l.userPropertiesToLog = userPropertiesToLog
+}
+
+func (l *RedisStreamLogger) GetUserPropertiesToLog() *[]log.UserProperty {
+	return l.userPropertiesToLog
+}
+
+func (l *RedisStreamLogger) Log(level log.LogLevel, message string, err error, ctx context.Context) {
+	if level >= l.minimumLevel {
+		// Skip this method to find the caller
+		l.LogEntry(log.NewEntry(level, message, err, ctx, 1))
+	}
+}
+
+// Queues the entry, with its caller's file and line, to append
+func (l *RedisStreamLogger) LogEntry(entry *log.Entry) {
+	if entry.Level >= l.minimumLevel {
+		values := map[string]string{
+			LogStreamTime:    entry.Time.UTC().Format(time.RFC3339Nano),
+			LogStreamLevel:   entry.Level.String(),
+			LogStreamService: l.serviceName,
+			LogStreamMessage: entry.Message,
+		}
+
+		if entry.Err != nil {
+			values[LogStreamError] = fmt.Sprintf("%+v", entry.Err)
+		}
+
+		if entry.Caller != nil {
+			values[LogStreamCaller] = entry.Caller.String()
+		}
+
+		if userProperties := log.GetUserPropertiesString(entry.Context, l.userPropertiesToLog); userProperties != nil {
+			values[LogStreamUser] = *userProperties
+		}
+
+		for key, value := range log.GetFields(entry.Context) {
+			values[LogStreamFieldPrefix+key] = value
+		}
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if l.closed {
+			return
+		}
+
+		select {
+		case l.queue <- values:
+		default:
+			l.dropped.Add(1)
+		}
+	}
+}
+
+func (l *RedisStreamLogger) Logf(level log.LogLevel, err error, ctx context.Context, format string, args ...interface{}) {
+	if level >= l.minimumLevel {
+		l.LogEntry(log.NewEntry(level, fmt.Sprintf(format, args...), err, ctx, 1))
+	}
+}
+
+func (l *RedisStreamLogger) Logln(level log.LogLevel, err error, ctx context.Context, args ...interface{}) {
+	if level >= l.minimumLevel {
+		l.LogEntry(log.NewEntry(level, strings.TrimSuffix(fmt.Sprintln(args...), "\n"), err, ctx, 1))
+	}
+}
+
+// Appends the queued entries and stops the background goroutine.  Entries not
+// appended before the timeout are dropped.  The Redis client is shared, so it
+// is not closed.
+func (l *RedisStreamLogger) Close(timeout time.Duration) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.queue)
+	l.mu.Unlock()
+
+	select {
+	case <-l.done:
+		return nil
+	case <-time.After(timeout):
+		l.cancel()
+		return fmt.Errorf("timed out appending %d queued entries to log stream %s", len(l.queue), l.stream)
+	}
+}
+
+// run appends queued entries until the queue is closed.  While appending
+// fails, only the first failure is reported, and the number of entries lost
+// is reported once appending succeeds again.
+func (l *RedisStreamLogger) run() {
+	defer close(l.done)
+	defer l.cancel()
+
+	var failed int
+	for values := range l.queue {
+		if l.ctx.Err() != nil {
+			// Closing timed out, so drop the remaining entries
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(l.ctx, logStreamWriteTimeout)
+		_, err := l.redis.AddStreamEntry(ctx, l.stream, l.maxLength, values)
+		cancel()
+
+		if err != nil {
+			if failed == 0 {
+				log.ReportSinkError("redis stream", fmt.Errorf("failed to append to %s: %w", l.stream, err))
+			}
+			failed++
+		} else if failed > 0 {
+			log.ReportSinkError("redis stream", fmt.Errorf("failed to append %d entries to %s", failed, l.stream))
+			failed = 0
+		}
+
+		if dropped := l.dropped.Swap(0); dropped > 0 {
+			log.ReportSinkError("redis stream", fmt.Errorf("dropped %d entries while the queue was full", dropped))
+		}
+	}
+}
+
+// An entry read from a log stream
+type LogStreamEntry struct {
+	// Stream entry ID
+	Id          string
+	Time        time.Time
+	Level       log.LogLevel
+	ServiceName string
+	Message     string
+	// Detailed error message, if any
+	Error string
+	// User properties, if any
+	User string
+	// Short file name and line of the caller, e.g., "server.go:42", if known
+	Caller string
+	Fields log.Fields
+}
+
+// String returns the entry formatted as a log line
+func (e LogStreamEntry) String() string {
+	message := e.Message
+	if e.Caller != "" {
+		message = fmt.Sprintf("%s: %s", e.Caller, message)
+	}
+
+	line := fmt.Sprintf("%s %s %s: %s", e.Time.Format(time.RFC3339), e.ServiceName, e.Level.String(), message)
+	if e.User != "" {
+		line = fmt.Sprintf("%s (%s)", line, e.User)
+	}
+	if len(e.Fields) > 0 {
+		line = fmt.Sprintf("%s [%s]", line, e.Fields.String())
+	}
+	if e.Error != "" {
+		line = fmt.Sprintf("%s, %s", line, e.Error)
+	}
+	return line
+}
+
+// A filter of log stream entries; zero values match all entries
+type LogStreamFilter struct {
+	MinimumLevel log.LogLevel
+	// Names of the services whose entries match
+	ServiceNames []string
+}
+
+// Matches reports whether the entry passes the filter
+func (f LogStreamFilter) Matches(entry LogStreamEntry) bool {
+	if entry.Level < f.MinimumLevel {
+		return false
+	}
+
+	if len(f.ServiceNames) == 0 {
+		return true
+	}
+	for _, serviceName := range f.ServiceNames {
+		if entry.ServiceName == serviceName {
+			return true
+		}
+	}
+	return false
+}
+
+// A reader that follows a log stream, see TailLogStream
+type LogStreamTail struct {
+	redis  *Redis
+	stream string
+	filter LogStreamFilter
+	lastId string
+
+	// Maximum entries read per request; defaults to 100
+	BatchSize int64
+	// Maximum time Follow waits for new entries per request when the end of the
+	// stream is reached; defaults to 1 second
+	PollInterval time.Duration
+}
+
+// TailLogStream returns a reader of the entries of a log stream matching the
+// filter, starting after the current end of the stream, or at the start of the
+// stream if fromStart is true
+func (r *Redis) TailLogStream(ctx context.Context, stream string, filter LogStreamFilter, fromStart bool) (*LogStreamTail, error) {
+	lastId := "0"
+	if !fromStart {
+		var err error
+		if lastId, err = r.LastStreamEntryId(ctx, stream); err != nil {
+			return nil, err
+		}
+	}
+
+	return &LogStreamTail{
+		redis:        r,
+		stream:       stream,
+		filter:       filter,
+		lastId:       lastId,
+		BatchSize:    100,
+		PollInterval: time.Second,
+	}, nil
+}
+
+// Next returns the matching entries appended since the previous call, without
+// blocking; the result is empty if there are no new matching entries
+func (t *LogStreamTail) Next(ctx context.Context) ([]LogStreamEntry, error) {
+	return t.read(ctx, 0)
+}
+
+// read returns the matching entries appended since the previous read, waiting
+// up to block for new entries
+func (t *LogStreamTail) read(ctx context.Context, block time.Duration) ([]LogStreamEntry, error) {
+	streamEntries, err := t.redis.ReadStreamEntries(ctx, t.stream, t.lastId, t.BatchSize, block)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []LogStreamEntry
+	for _, streamEntry := range streamEntries {
+		t.lastId = streamEntry.Id
+
+		if entry := parseLogStreamEntry(streamEntry); t.filter.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// Follow calls handler for each matching entry as it is appended to the
+// stream, until the context is done or reading fails
+func (t *LogStreamTail) Follow(ctx context.Context, handler func(LogStreamEntry)) error {
+	for {
+		// Blocks until entries are appended or the poll interval elapses
+		entries, err := t.read(ctx, t.PollInterval)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			handler(entry)
+		}
+	}
+}
+
+// parseLogStreamEntry returns the log entry stored in a stream entry
+func parseLogStreamEntry(streamEntry StreamEntry) LogStreamEntry {
+	entry := LogStreamEntry{
+		Id:          streamEntry.Id,
+		Level:       log.GetLogLevel(streamEntry.Values[LogStreamLevel]),
+		ServiceName: streamEntry.Values[LogStreamService],
+		Message:     streamEntry.Values[LogStreamMessage],
+		Error:       streamEntry.Values[LogStreamError],
+		User:        streamEntry.Values[LogStreamUser],
+		Caller:      streamEntry.Values[LogStreamCaller],
+	}
+
+	entry.Time, _ = time.Parse(time.RFC3339Nano, streamEntry.Values[LogStreamTime])
+
+	for key, value := range streamEntry.Values {
+		if strings.HasPrefix(key, LogStreamFieldPrefix) {
+			if entry.Fields == nil {
+				entry.Fields = log.Fields{}
+			}
+			entry.Fields[strings.TrimPrefix(key, LogStreamFieldPrefix)] = value
+		}
+	}
+
+	return entry
+}
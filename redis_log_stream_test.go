@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Adapptor/service/v2/log"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisStreamLoggerTail(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	stream := testKeyPrefix(t, r) + "logs"
+
+	// Entries logged before tailing from the end are skipped
+	before := NewRedisStreamLogger(r, stream, "api", DefaultLogStreamMaxLength, log.Debug)
+	before.Log(log.Error, "before tail", nil, nil)
+	if err := before.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	tail, err := r.TailLogStream(ctx, stream, LogStreamFilter{MinimumLevel: log.Warning, ServiceNames: []string{"api"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userProperties := map[log.UserProperty]string{log.UserPropertyId: "42"}
+	entryCtx := context.WithValue(context.Background(), log.UserPropertiesKey, &userProperties)
+	entryCtx = log.WithFields(entryCtx, log.Fields{"orderId": "1234", "region": "au"})
+	api := NewRedisStreamLogger(r, stream, "api", DefaultLogStreamMaxLength, log.Debug)
+	worker := NewRedisStreamLogger(r, stream, "worker", DefaultLogStreamMaxLength, log.Debug)
+	api.SetUserPropertiesToLog(&[]log.UserProperty{log.UserPropertyId})
+
+	api.Log(log.Error, "payment failed", errors.New("card declined"), entryCtx)
+	api.Log(log.Info, "below the minimum level", nil, nil)
+	worker.Log(log.Error, "another service", nil, nil)
+
+	// Closing appends the queued entries
+	if err := api.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := worker.Close(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := tail.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 matching entry, got %+v", entries)
+	}
+
+	entry := entries[0]
+	if entry.Message != "payment failed" || entry.Level != log.Error || entry.ServiceName != "api" || entry.Error != "card declined" || entry.User != "42" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	if entry.Fields["orderId"] != "1234" || entry.Fields["region"] != "au" || len(entry.Fields) != 2 {
+		t.Errorf("expected the fields without their prefix, got %v", entry.Fields)
+	}
+	if entry.Caller == "" || time.Since(entry.Time) > time.Minute {
+		t.Errorf("expected the caller and time, got %q %v", entry.Caller, entry.Time)
+	}
+
+	// Entries are only returned once
+	if entries, err := tail.Next(ctx); err != nil || len(entries) != 0 {
+		t.Errorf("expected no new entries, got %+v, %v", entries, err)
+	}
+
+	// The whole stream is read from the start
+	fromStart, err := r.TailLogStream(ctx, stream, LogStreamFilter{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := fromStart.Next(ctx); err != nil || len(entries) != 4 || entries[0].Message != "before tail" {
+		t.Errorf("expected all 4 entries from the start, got %+v, %v", entries, err)
+	}
+}
+
+func TestLogStreamTailFollow(t *testing.T) {
+	r := testRedis(t)
+	stream := testKeyPrefix(t, r) + "logs"
+	logger := NewRedisStreamLogger(r, stream, "api", 0, log.Info)
+	defer logger.Close(time.Second)
+
+	tail, err := r.TailLogStream(context.Background(), stream, LogStreamFilter{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tail.PollInterval = 100 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		logger.Log(log.Info, "first", nil, nil)
+		logger.Log(log.Info, "second", nil, nil)
+	}()
+
+	var messages []string
+	err = tail.Follow(ctx, func(entry LogStreamEntry) {
+		messages = append(messages, entry.Message)
+		if len(messages) == 2 {
+			cancel()
+		}
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Follow to stop when the context is cancelled, got %v", err)
+	}
+	if len(messages) != 2 || messages[0] != "first" || messages[1] != "second" {
+		t.Errorf("unexpected messages %v", messages)
+	}
+}
+
+// Test logging does not block when Redis does not respond, and Close returns
+// after its timeout
+func TestRedisStreamLoggerUnresponsive(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// Accept connections without responding
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	r := NewRedis(&redis.UniversalOptions{Addrs: []string{listener.Addr().String()}})
+	defer r.Close()
+	logger := NewRedisStreamLogger(r, "logs", "api", 0, log.Info)
+
+	start := time.Now()
+	for i := 0; i < 2*logStreamQueueSize; i++ {
+		logger.Log(log.Info, "unresponsive", nil, nil)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected logging not to block on Redis, took %v", elapsed)
+	}
+
+	start = time.Now()
+	if err := logger.Close(100 * time.Millisecond); err == nil {
+		t.Error("expected an error closing with entries not appended")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Close to return after its timeout, took %v", elapsed)
+	}
+}
+
+func TestParseLogStreamEntry(t *testing.T) {
+	entry := parseLogStreamEntry(StreamEntry{
+		Id: "1526919030474-55",
+		Values: map[string]string{
+			LogStreamTime:                 "2024-05-01T02:03:04.5Z",
+			LogStreamLevel:                "WARNING",
+			LogStreamService:              "api",
+			LogStreamMessage:              "slow request",
+			LogStreamCaller:               "server.go:42",
+			LogStreamFieldPrefix + "path": "/orders",
+			"unrelated":                   "ignored",
+		},
+	})
+
+	if entry.Id != "1526919030474-55" || entry.Level != log.Warning || entry.ServiceName != "api" || entry.Caller != "server.go:42" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	if !entry.Time.Equal(time.Date(2024, 5, 1, 2, 3, 4, 500000000, time.UTC)) {
+		t.Errorf("unexpected time %v", entry.Time)
+	}
+	if len(entry.Fields) != 1 || entry.Fields["path"] != "/orders" {
+		t.Errorf("unexpected fields %v", entry.Fields)
+	}
+	if line := entry.String(); line != "2024-05-01T02:03:04Z api WARNING: server.go:42: slow request [path=/orders]" {
+		t.Errorf("unexpected line %q", line)
+	}
+}
@@ -0,0 +1,340 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/getsentry/sentry-go"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// In-process Redis server shared by tests when REDIS_ADDR is not set
+var (
+	testMiniredisOnce sync.Once
+	testMiniredis     *miniredis.Miniredis
+	testMiniredisErr  error
+)
+
+// testRedis connects to the Redis at REDIS_ADDR, or otherwise to an in-process
+// miniredis server shared by all tests.  Tests use unique keys, see testKeyPrefix.
+func testRedis(t *testing.T) *Redis {
+	t.Helper()
+
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		testMiniredisOnce.Do(startTestMiniredis)
+		if testMiniredisErr != nil {
+			t.Fatal(testMiniredisErr)
+		}
+		addr = testMiniredis.Addr()
+	}
+
+	r := NewRedis(&redis.UniversalOptions{Addrs: []string{addr}})
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// startTestMiniredis starts the shared miniredis server, moving its clock
+// forward in real time, as miniredis only expires keys when its clock moves
+func startTestMiniredis() {
+	testMiniredis, testMiniredisErr = miniredis.Run()
+	if testMiniredisErr != nil {
+		return
+	}
+
+	go func() {
+		last := time.Now()
+		for now := range time.Tick(10 * time.Millisecond) {
+			testMiniredis.FastForward(now.Sub(last))
+			last = now
+		}
+	}()
+}
+
+// testKeyPrefix returns a unique key prefix for a test, e.g., "test:<random>:",
+// deleting all keys containing the prefix after the test, so it can also
+// prefix the names of locks, rate limiters and job queues
+func testKeyPrefix(t *testing.T, r *Redis) string {
+	prefix := fmt.Sprintf("test:%016x:", rand.Uint64())
+	t.Cleanup(func() {
+		r.DeleteKeys(context.Background(), "*"+prefix+"*", DeleteKeysOptions{})
+	})
+	return prefix
+}
+
+func TestStreamEntries(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	stream := testKeyPrefix(t, r) + "stream"
+
+	if id, err := r.LastStreamEntryId(ctx, stream); err != nil || id != "0" {
+		t.Fatalf("expected ID 0 for an empty stream, got %q, %v", id, err)
+	}
+	if entries, err := r.ReadStreamEntries(ctx, stream, "0", 10, 0); err != nil || len(entries) != 0 {
+		t.Fatalf("expected no entries, got %v, %v", entries, err)
+	}
+
+	first, err := r.AddStreamEntry(ctx, stream, 0, map[string]string{"message": "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := r.AddStreamEntry(ctx, stream, 0, map[string]string{"message": "second", "level": "INFO"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err := r.LastStreamEntryId(ctx, stream); err != nil || id != second {
+		t.Errorf("expected the last ID %s, got %q, %v", second, id, err)
+	}
+
+	entries, err := r.ReadStreamEntries(ctx, stream, "0", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Id != first || entries[1].Values["message"] != "second" || entries[1].Values["level"] != "INFO" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+
+	if entries, err := r.ReadStreamEntries(ctx, stream, first, 10, 0); err != nil || len(entries) != 1 || entries[0].Id != second {
+		t.Errorf("expected the entry after %s, got %+v, %v", first, entries, err)
+	}
+
+	// Blocking reads wait for entries
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		r.AddStreamEntry(ctx, stream, 0, map[string]string{"message": "third"})
+	}()
+	entries, err = r.ReadStreamEntries(ctx, stream, second, 10, 2*time.Second)
+	if err != nil || len(entries) != 1 || entries[0].Values["message"] != "third" {
+		t.Errorf("expected the blocking read to return the new entry, got %+v, %v", entries, err)
+	}
+}
+
+func TestDeleteKeys(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	prefix := testKeyPrefix(t, r)
+
+	for i := 0; i < 25; i++ {
+		if err := r.Set(ctx, fmt.Sprintf("%sdelete:%d", prefix, i), i, 0).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Set(ctx, prefix+"keep", "kept", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := r.KeyCount(ctx, prefix+"delete:*"); err != nil || count != 25 {
+		t.Fatalf("expected 25 keys to delete, got %d, %v", count, err)
+	}
+
+	var progress []int64
+	deleted, err := r.DeleteKeys(ctx, prefix+"delete:*", DeleteKeysOptions{
+		BatchSize: 10,
+		Progress:  func(deleted int64) { progress = append(progress, deleted) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 25 {
+		t.Errorf("expected 25 keys deleted, got %d", deleted)
+	}
+
+	if len(progress) == 0 || progress[len(progress)-1] != 25 {
+		t.Fatalf("expected progress up to 25, got %v", progress)
+	}
+	for i := 1; i < len(progress); i++ {
+		if progress[i] <= progress[i-1] {
+			t.Errorf("expected increasing progress, got %v", progress)
+		}
+	}
+
+	if count, err := r.KeyCount(ctx, prefix+"*"); err != nil || count != 1 {
+		t.Errorf("expected only the unmatched key to remain, got %d, %v", count, err)
+	}
+
+	if deleted, err := r.DeleteKeysPrefix(ctx, prefix+"delete:*"); err != nil || deleted != 0 {
+		t.Errorf("expected nothing left to delete, got %d, %v", deleted, err)
+	}
+}
+
+func TestDeleteKeysCancelled(t *testing.T) {
+	r := testRedis(t)
+	prefix := testKeyPrefix(t, r)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := r.DeleteKeys(ctx, prefix+"*", DeleteKeysOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancelled deletion to fail, got %v", err)
+	}
+	if _, err := r.KeyCount(ctx, prefix+"*"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancelled count to fail, got %v", err)
+	}
+}
+
+func TestProtobufHelpers(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	key := testKeyPrefix(t, r) + "order"
+
+	recorder := httptest.NewRecorder()
+	if err := r.WriteProtobufKey(ctx, recorder, key, &wrapperspb.StringValue{}, true); !errors.Is(err, redis.Nil) || recorder.Code != http.StatusNotFound {
+		t.Errorf("expected a missing key to respond 404, got %d, %v", recorder.Code, err)
+	}
+
+	if err := r.SetCachedProtobuf(ctx, key, wrapperspb.String("order 1234"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	var order wrapperspb.StringValue
+	if err := r.GetProtobufKey(ctx, key, &order); err != nil || order.Value != "order 1234" {
+		t.Errorf("unexpected protobuf %v, %v", order.Value, err)
+	}
+
+	recorder = httptest.NewRecorder()
+	if err := r.WriteProtobufKey(ctx, recorder, key, &wrapperspb.StringValue{}, true); err != nil || !strings.Contains(recorder.Body.String(), `"order 1234"`) {
+		t.Errorf("unexpected response %q, %v", recorder.Body.String(), err)
+	}
+
+	if ttl, err := r.TTL(ctx, key).Result(); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected the expiry to be set, got %v, %v", ttl, err)
+	}
+}
+
+// Test NewRedis does not modify the given options
+func TestNewRedisOptions(t *testing.T) {
+	options := &redis.UniversalOptions{Addrs: []string{"localhost:6379"}}
+	r := NewRedis(options)
+	defer r.Close()
+
+	if options.ContextTimeoutEnabled {
+		t.Error("expected the options not to be modified")
+	}
+}
+
+func TestSentryRedisHook(t *testing.T) {
+	transport := initSentryTracing(t)
+	r := testRedis(t)
+	prefix := testKeyPrefix(t, r)
+	// Wrapping the client again does not record commands twice
+	r = NewRedisWithClient(r.UniversalClient)
+
+	transaction := sentry.StartTransaction(context.Background(), "test")
+	ctx := transaction.Context()
+
+	r.Set(ctx, prefix+"order", "order 1234", time.Minute)
+	r.Get(ctx, prefix+"missing")
+	r.Incr(ctx, prefix+"order")
+	r.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Get(ctx, prefix+"order")
+		pipe.TTL(ctx, prefix+"order")
+		return nil
+	})
+	r.Eval(ctx, "return redis.call('GET', KEYS[1])", []string{prefix + "order"})
+	// Commands without a span in the context are not recorded
+	r.Get(context.Background(), prefix+"order")
+
+	transaction.Finish()
+	sentry.Flush(0)
+
+	events := transactions(transport)
+	if len(events) != 1 {
+		t.Fatalf("expected 1 transaction, got %d", len(events))
+	}
+
+	var spans []string
+	for _, span := range events[0].Spans {
+		if span.Op != "db.redis" || span.Data["db.system"] != "redis" {
+			t.Errorf("unexpected span %s %v", span.Op, span.Data)
+		}
+		spans = append(spans, fmt.Sprintf("%s %s", span.Description, span.Status))
+	}
+
+	expected := []string{
+		"SET " + prefix + "order ok",
+		// A missing key is not a failure
+		"GET " + prefix + "missing ok",
+		"INCR " + prefix + "order internal_error",
+		"PIPELINE 2 commands ok",
+		"EVAL " + prefix + "order ok",
+	}
+	if strings.Join(spans, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected spans:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(spans, "\n"))
+	}
+}
+
+func TestJsonHelpers(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	key := testKeyPrefix(t, r) + "order"
+
+	var order map[string]string
+	if err := r.ReadJson(ctx, key, &order); !errors.Is(err, redis.Nil) {
+		t.Errorf("expected redis.Nil for a missing key, got %v", err)
+	}
+
+	r.CacheJson(ctx, key, map[string]string{"id": "1234"}, time.Minute)
+	if err := r.ReadJson(ctx, key, &order); err != nil || order["id"] != "1234" {
+		t.Errorf("unexpected order %v, %v", order, err)
+	}
+}
+
+func TestCacheKeyWriter(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	key := testKeyPrefix(t, r) + "report"
+
+	writer := r.CacheKeyWriter(ctx, key, time.Minute)
+	fmt.Fprint(writer, "first,")
+	fmt.Fprint(writer, "second")
+
+	if value, err := r.Get(ctx, key).Result(); err != nil || value != "first,second" {
+		t.Errorf("expected the writes to be appended, got %q, %v", value, err)
+	}
+	if ttl, err := r.TTL(ctx, key).Result(); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected the expiry to be set, got %v, %v", ttl, err)
+	}
+}
+
+func TestStatHelpers(t *testing.T) {
+	ctx := context.Background()
+	r := testRedis(t)
+	prefix := testKeyPrefix(t, r)
+
+	for _, member := range []string{"apples", "pears", "apples"} {
+		if err := r.StatRecordIncr(ctx, prefix+"fruit", 1, member); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if members, err := r.StatRevRange(ctx, prefix+"fruit"); err != nil || strings.Join(members, ",") != "apples,pears" {
+		t.Errorf("expected members by descending score, got %v, %v", members, err)
+	}
+
+	if err := r.StatRecordHourValue(ctx, prefix+"visits", "42"); err != nil {
+		t.Fatal(err)
+	}
+	now := PerthNow()
+	hourKey := fmt.Sprintf("%vvisits:%v", prefix, now.Format("20060102"))
+	if value, err := r.HGet(ctx, hourKey, now.Format("15")).Result(); err != nil || value != "42" {
+		t.Errorf("expected the hour value, got %q, %v", value, err)
+	}
+
+	if err := r.SAddCachedProtobuf(ctx, prefix+"orders", wrapperspb.String("order 1234")); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := r.SCard(ctx, prefix+"orders").Result(); err != nil || count != 1 {
+		t.Errorf("expected 1 member, got %d, %v", count, err)
+	}
+}
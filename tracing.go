@@ -0,0 +1,268 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/redis/go-redis/v9"
+)
+
+// SentryTracingHandler wraps a handler to start a Sentry transaction for each
+// request, continuing any trace propagated in the request headers.
+//
+// The transaction is stored in the request context, so spans started from the
+// context (e.g., by Redis commands and NewHttpClient) are recorded in the
+// transaction, and errors logged with the context are linked to its trace.
+//
+// Transactions are only sent if tracing is enabled, see log.NewSentryLoggerWithTracing.
+// If the request context has no Sentry hub, the request is given its own hub as
+// with SentryHubHandler.
+func SentryTracingHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withSentryHub(r)
+
+		transaction := sentry.StartTransaction(r.Context(),
+			fmt.Sprintf("%s %s", r.Method, r.URL.Path),
+			sentry.WithOpName("http.server"),
+			sentry.WithTransactionSource(sentry.SourceURL),
+			sentry.ContinueFromRequest(r),
+		)
+		transaction.SetData("http.request.method", r.Method)
+		defer transaction.Finish()
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(transaction.Context()))
+
+		status := recorder.Status()
+		transaction.Status = sentry.HTTPtoSpanStatus(status)
+		transaction.SetData("http.response.status_code", status)
+	})
+}
+
+// SentryHubHandler wraps a handler to give each request its own Sentry hub,
+// cloned from the global hub and stored in the request context.
+//
+// Breadcrumbs and events logged with the request context are recorded on the
+// request's hub, so each event carries only the breadcrumbs of its own request.
+func SentryHubHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, withSentryHub(r))
+	})
+}
+
+// withSentryHub returns the request with a Sentry hub cloned from the global
+// hub in its context, or the request unchanged if its context already has a hub
+func withSentryHub(r *http.Request) *http.Request {
+	if sentry.GetHubFromContext(r.Context()) != nil {
+		return r
+	}
+
+	hub := sentry.CurrentHub().Clone()
+	hub.Scope().SetRequest(r)
+
+	return r.WithContext(sentry.SetHubOnContext(r.Context(), hub))
+}
+
+// An http.RoundTripper that records outbound requests as spans of the Sentry
+// transaction in the request context, and propagates the trace to the server
+// in the request headers.  Requests without a transaction are not traced.
+type SentryTracingTransport struct {
+	// The transport that makes requests; http.DefaultTransport if nil
+	Base http.RoundTripper
+}
+
+func (t *SentryTracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	parent := sentry.SpanFromContext(req.Context())
+	if parent == nil {
+		return base.RoundTrip(req)
+	}
+
+	span := parent.StartChild("http.client", sentry.WithDescription(fmt.Sprintf("%s %s", req.Method, req.URL.Redacted())))
+	span.SetData("http.request.method", req.Method)
+	defer span.Finish()
+
+	// A RoundTripper must not modify the request, so add the trace headers to a clone
+	req = req.Clone(span.Context())
+	req.Header.Set(sentry.SentryTraceHeader, span.ToSentryTrace())
+	req.Header.Set(sentry.SentryBaggageHeader, span.ToBaggage())
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		span.Status = sentry.SpanStatusInternalError
+	} else {
+		span.Status = sentry.HTTPtoSpanStatus(resp.StatusCode)
+		span.SetData("http.response.status_code", resp.StatusCode)
+	}
+
+	return resp, err
+}
+
+// Context key marking Redis commands already recorded as a span, so a client
+// with the hook added more than once records each command once
+type redisSpanKey struct{}
+
+// startRedisSpan starts a Sentry span for a Redis command if the given context has
+// a span and the command is not already recorded, otherwise returns nil
+func startRedisSpan(ctx context.Context, description string) *sentry.Span {
+	if ctx == nil || sentry.SpanFromContext(ctx) == nil || ctx.Value(redisSpanKey{}) != nil {
+		return nil
+	}
+
+	span := sentry.StartSpan(ctx, "db.redis", sentry.WithDescription(description))
+	span.SetData("db.system", "redis")
+
+	return span
+}
+
+// withRedisSpan marks the context of a command recorded as the given span, if any
+func withRedisSpan(ctx context.Context, span *sentry.Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, redisSpanKey{}, true)
+}
+
+// A go-redis hook that records commands as spans of the Sentry transaction in
+// the command context
+type sentryRedisHook struct{}
+
+func (sentryRedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (sentryRedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if isRedisConnectionCommand(cmd) {
+			return next(ctx, cmd)
+		}
+
+		span := startRedisSpan(ctx, redisCommandDescription(cmd))
+		err := next(withRedisSpan(ctx, span), cmd)
+		// A missing key is not a failure
+		finishSpan(span, ignoreNil(err))
+		return err
+	}
+}
+
+func (sentryRedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		connection := true
+		for _, cmd := range cmds {
+			connection = connection && isRedisConnectionCommand(cmd)
+		}
+		if connection {
+			return next(ctx, cmds)
+		}
+
+		span := startRedisSpan(ctx, fmt.Sprintf("PIPELINE %d commands", len(cmds)))
+		err := next(withRedisSpan(ctx, span), cmds)
+		finishSpan(span, ignoreNil(err))
+		return err
+	}
+}
+
+// isRedisConnectionCommand reports whether the command sets up a connection
+// (e.g., HELLO or CLIENT SETINFO), which go-redis sends with the context of
+// the first command on a new connection, so it is not traced
+func isRedisConnectionCommand(cmd redis.Cmder) bool {
+	switch strings.ToLower(cmd.Name()) {
+	case "hello", "auth", "select", "client", "readonly":
+		return true
+	}
+	return false
+}
+
+// redisCommandDescription returns the name and key of a command, e.g., "GET user:42"
+func redisCommandDescription(cmd redis.Cmder) string {
+	name := strings.ToUpper(cmd.Name())
+	args := cmd.Args()
+
+	switch name {
+	case "EVAL", "EVALSHA", "EVAL_RO", "EVALSHA_RO":
+		// The first key follows the script and number of keys
+		if len(args) > 3 && fmt.Sprint(args[2]) != "0" {
+			return fmt.Sprintf("%s %v", name, args[3])
+		}
+		return name
+	}
+
+	if len(args) > 1 {
+		return fmt.Sprintf("%s %v", name, args[1])
+	}
+	return name
+}
+
+// finishSpan finishes a span that may be nil, recording the error if present
+func finishSpan(span *sentry.Span, err error) {
+	if span == nil {
+		return
+	}
+
+	if err != nil {
+		span.Status = sentry.SpanStatusInternalError
+	} else {
+		span.Status = sentry.SpanStatusOK
+	}
+	span.Finish()
+}
+
+// An http.ResponseWriter that records the response status code
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Status returns the response status code, which is 200 if the handler did not write a header
+func (r *statusRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// Flush sends buffered data to the client, if the wrapped writer supports flushing
+func (r *statusRecorder) Flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+// Hijack takes over the connection, e.g., for websockets, if the wrapped writer
+// supports hijacking, otherwise returns http.ErrNotSupported
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the wrapped writer, for use by http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
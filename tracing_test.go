@@ -0,0 +1,161 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// initSentryTracing initialises Sentry with tracing of all transactions, sent to the returned transport
+func initSentryTracing(t *testing.T) *sentry.MockTransport {
+	t.Helper()
+
+	transport := &sentry.MockTransport{}
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:              "https://public@example.com/1",
+		Transport:        transport,
+		EnableTracing:    true,
+		TracesSampleRate: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return transport
+}
+
+// transactions returns the transaction events sent to the transport
+func transactions(transport *sentry.MockTransport) []*sentry.Event {
+	var transactions []*sentry.Event
+	for _, event := range transport.Events() {
+		if event.Type == "transaction" {
+			transactions = append(transactions, event)
+		}
+	}
+	return transactions
+}
+
+func TestSentryTracingHandler(t *testing.T) {
+	transport := initSentryTracing(t)
+
+	var traceHeader, baggageHeader string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceHeader = r.Header.Get(sentry.SentryTraceHeader)
+		baggageHeader = r.Header.Get(sentry.SentryBaggageHeader)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: &SentryTracingTransport{}}
+	handler := SentryTracingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/orders/1", nil))
+	sentry.Flush(0)
+
+	events := transactions(transport)
+	if len(events) != 1 {
+		t.Fatalf("expected 1 transaction, got %d", len(events))
+	}
+	transaction := events[0]
+
+	if transaction.Transaction != "GET /orders/1" || transaction.Contexts["trace"]["status"] != sentry.SpanStatusUnavailable {
+		t.Errorf("unexpected transaction %q with trace context %v", transaction.Transaction, transaction.Contexts["trace"])
+	}
+
+	if len(transaction.Spans) != 1 || transaction.Spans[0].Op != "http.client" || transaction.Spans[0].Status != sentry.HTTPtoSpanStatus(http.StatusTeapot) {
+		t.Fatalf("expected an http.client span with the upstream status, got %+v", transaction.Spans)
+	}
+	span := transaction.Spans[0]
+
+	if !strings.HasPrefix(traceHeader, span.TraceID.String()+"-"+span.SpanID.String()) {
+		t.Errorf("expected the trace of the span to be propagated, got %q", traceHeader)
+	}
+	if !strings.Contains(baggageHeader, "sentry-trace_id="+span.TraceID.String()) {
+		t.Errorf("expected the trace in the baggage, got %q", baggageHeader)
+	}
+}
+
+func TestSentryTracingHandlerContinuesTrace(t *testing.T) {
+	transport := initSentryTracing(t)
+
+	handler := SentryTracingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set(sentry.SentryTraceHeader, "d49d9bf66f13450b81f65bc51cf49c03-1cc4b26ab9094ef0-1")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	sentry.Flush(0)
+
+	events := transactions(transport)
+	if len(events) != 1 || events[0].Contexts["trace"]["trace_id"].(sentry.TraceID).String() != "d49d9bf66f13450b81f65bc51cf49c03" {
+		t.Fatalf("expected the propagated trace to be continued, got %+v", events)
+	}
+	if status := events[0].Contexts["trace"]["status"]; status != sentry.SpanStatusOK {
+		t.Errorf("expected status ok, got %v", status)
+	}
+}
+
+func TestSentryTracingHandlerFlushAndHijack(t *testing.T) {
+	initSentryTracing(t)
+
+	server := httptest.NewServer(SentryTracingHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: 1\n\n"))
+			w.(http.Flusher).Flush()
+			return
+		}
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "data: 1\n\n" {
+		t.Errorf("unexpected event stream %q", body)
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL+"/upgrade", nil)
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "test")
+	conn, err := (&http.Transport{}).RoundTrip(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Body.Close()
+	if conn.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("expected the connection to be hijacked, got status %d", conn.StatusCode)
+	}
+
+	// A writer that cannot hijack reports that hijacking is not supported
+	recorder := &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+	if _, _, err := recorder.Hijack(); err == nil {
+		t.Error("expected hijacking a recorder to fail")
+	}
+}